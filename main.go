@@ -19,15 +19,32 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-github/v60/github"
 	"github.com/stacklok/frizbee-action/pkg/action"
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/replacer"
 	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/ghrest"
 
 	"golang.org/x/oauth2"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// exitUnpinned is the exit code of a run which found unpinned references
+	exitUnpinned = 1
+	// exitUnresolved is the exit code of a run which couldn't resolve some references, whether it found unpinned
+	// references or not
+	exitUnresolved = 2
 )
 
 func main() {
@@ -41,9 +58,13 @@ func main() {
 	// Run the frizbee action
 	err = frizbeeAction.Run(ctx)
 	if err != nil {
+		if errors.Is(err, action.ErrUnresolvedFound) {
+			log.Printf("Error running action: %v", err)
+			os.Exit(exitUnresolved)
+		}
 		if errors.Is(err, action.ErrUnpinnedFound) {
 			log.Printf("Unpinned actions or container images found. Check the Frizbee Action logs for more information.")
-			os.Exit(1)
+			os.Exit(exitUnpinned)
 		}
 		log.Fatalf("Error running action: %v", err)
 	}
@@ -57,9 +78,32 @@ func initAction(ctx context.Context) (*action.FrizbeeAction, error) {
 		return nil, fmt.Errorf("GITHUB_TOKEN environment variable is not set")
 	}
 
+	// Read the boolean inputs, failing once all the inputs are read if any of them is invalid
+	bools := &boolInputs{}
+
 	// Create a new GitHub client
 	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 	tc := oauth2.NewClient(ctx, ts)
+	client := github.NewClient(tc)
+
+	// Point the client and the git operations to the GitHub Enterprise Server host, if running on one
+	if serverURL := os.Getenv("GITHUB_SERVER_URL"); serverURL != "" {
+		host, err := pull_request.ServerHost(serverURL)
+		if err != nil {
+			return nil, err
+		}
+		if host != pull_request.DefaultHost {
+			apiURL := os.Getenv("GITHUB_API_URL")
+			if apiURL == "" {
+				apiURL = serverURL
+			}
+			if client, err = client.WithEnterpriseURLs(apiURL, serverURL); err != nil {
+				return nil, fmt.Errorf("failed to configure the GitHub Enterprise Server client: %w", err)
+			}
+		}
+		pull_request.Host = host
+	}
+	pull_request.Token = token
 
 	// Get the GITHUB_REPOSITORY_OWNER
 	repoOwner := os.Getenv("GITHUB_REPOSITORY_OWNER")
@@ -73,18 +117,624 @@ func initAction(ctx context.Context) (*action.FrizbeeAction, error) {
 		return nil, fmt.Errorf("GITHUB_REPOSITORY environment variable is not set")
 	}
 
+	// Run against another repository the token has access to, i.e. from a central security automation repository,
+	// rather than the one of the workflow, whose event then doesn't apply
+	targetRepository := strings.TrimSpace(os.Getenv("INPUT_TARGET_REPOSITORY"))
+	otherRepository := targetRepository != "" && !strings.EqualFold(targetRepository, repoFullName)
+	if otherRepository {
+		owner, name, ok := strings.Cut(targetRepository, "/")
+		if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("INPUT_TARGET_REPOSITORY must be owner/name, got %q", targetRepository)
+		}
+		repoOwner, repoFullName = owner, targetRepository
+	}
+	cloneTarget := bools.get("INPUT_CLONE_TARGET_REPOSITORY", false)
+
+	// Get the number of context lines to show around each change in the generated diffs
+	diffContext, err := getIntInput("INPUT_DIFF_CONTEXT", action.DefaultDiffContext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound the number of concurrent tag to SHA and image to digest lookups across all the files
+	resolveConcurrency, err := getIntInput("INPUT_RESOLVE_CONCURRENCY", action.DefaultResolveConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	if resolveConcurrency == 0 {
+		return nil, fmt.Errorf("INPUT_RESOLVE_CONCURRENCY must be at least 1")
+	}
+	sem := action.NewSemaphore(resolveConcurrency)
+
+	// Retry the registry requests failing with transient errors
+	registryRetries, err := getIntInput("INPUT_REGISTRY_RETRIES", action.DefaultRegistryRetries)
+	if err != nil {
+		return nil, err
+	}
+	resolveErrors := &action.ResolveErrors{}
+	var rest interfaces.REST = ghrest.NewClient(token)
+
+	// Record the resolution requests to explain how each reference was resolved, if requested
+	var tracer *action.Tracer
+	if bools.get("INPUT_EXPLAIN", false) {
+		tracer = &action.Tracer{}
+		rest = action.TraceREST(rest, tracer)
+		remote.DefaultTransport = action.TraceTransport(remote.DefaultTransport, tracer)
+	}
+
+	remote.DefaultTransport = action.LimitTransport(
+		action.RetryTransport(remote.DefaultTransport, registryRetries, resolveErrors), sem)
+	rest = action.RecordUnresolvableREST(rest, resolveErrors)
+	if bools.get("INPUT_GIT_LSREMOTE_FALLBACK", false) {
+		rest = action.LsRemoteFallback(rest)
+	}
+	actionsReplacer := replacer.NewGitHubActionsReplacer(&config.Config{}).WithGitHubClient(action.LimitREST(rest, sem))
+
+	// Get the maximum number of files to scan
+	maxFiles, err := getIntInput("INPUT_MAX_FILES", action.DefaultMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the maximum time to spend processing a single file
+	var timeoutPerFile time.Duration
+	if value := os.Getenv("INPUT_REPLACER_TIMEOUT_PER_FILE"); value != "" {
+		timeoutPerFile, err = time.ParseDuration(value)
+		if err != nil || timeoutPerFile < 0 {
+			return nil, fmt.Errorf("INPUT_REPLACER_TIMEOUT_PER_FILE must be a positive duration, i.e. 30s, got %q", value)
+		}
+	}
+
+	// Get how long to wait for the concurrent runs to push their changes, the runs not waiting for each other if unset
+	var lockTimeout time.Duration
+	if value := os.Getenv("INPUT_LOCK_TIMEOUT"); value != "" {
+		lockTimeout, err = time.ParseDuration(value)
+		if err != nil || lockTimeout < 0 {
+			return nil, fmt.Errorf("INPUT_LOCK_TIMEOUT must be a positive duration, i.e. 5m, got %q", value)
+		}
+	}
+
+	// Get the git remote to push the branch to, defaulting to the repository itself
+	gitRemote := os.Getenv("INPUT_GIT_REMOTE")
+	if forkOwner := strings.TrimSpace(os.Getenv("INPUT_FORK_OWNER")); forkOwner != "" {
+		if gitRemote != "" && gitRemote != pull_request.DefaultRemote {
+			return nil, fmt.Errorf("INPUT_FORK_OWNER can't be used with INPUT_GIT_REMOTE")
+		}
+		// Push to the fork of the same name, with the token, and open the pull request from it
+		gitRemote = fmt.Sprintf("https://%s/%s/%s.git", pull_request.Host, forkOwner,
+			strings.TrimPrefix(repoFullName, repoOwner+"/"))
+	}
+	if gitRemote == "" {
+		gitRemote = pull_request.DefaultRemote
+	}
+
+	// Signed commits are created with the API of the repository itself, not pushed to a fork
+	signedCommits := bools.get("INPUT_SIGNED_COMMITS", false)
+	if signedCommits && gitRemote != pull_request.DefaultRemote {
+		return nil, fmt.Errorf("INPUT_SIGNED_COMMITS can't be used with INPUT_GIT_REMOTE")
+	}
+
+	// Sign the commits made with git with the GPG key, if given
+	pull_request.GPGPrivateKey = os.Getenv("INPUT_GPG_PRIVATE_KEY")
+	pull_request.GPGPassphrase = os.Getenv("INPUT_GPG_PASSPHRASE")
+	if signedCommits && pull_request.GPGPrivateKey != "" {
+		return nil, fmt.Errorf("INPUT_SIGNED_COMMITS can't be used with INPUT_GPG_PRIVATE_KEY")
+	}
+
+	// Override the author of the commits, credit the actor who triggered the workflow and sign the commits off, i.e.
+	// for the DCO checks of the repository
+	pull_request.AuthorName = strings.TrimSpace(os.Getenv("INPUT_AUTHOR_NAME"))
+	pull_request.AuthorEmail = strings.TrimSpace(os.Getenv("INPUT_AUTHOR_EMAIL"))
+	pull_request.Signoff = bools.get("INPUT_SIGNOFF", false)
+	if signedCommits && (pull_request.AuthorName != "" || pull_request.AuthorEmail != "" || pull_request.Signoff) {
+		return nil, fmt.Errorf("INPUT_SIGNED_COMMITS can't be used with INPUT_AUTHOR_NAME, INPUT_AUTHOR_EMAIL or " +
+			"INPUT_SIGNOFF, the API commits being authored by the token")
+	}
+	if pull_request.GPGPrivateKey != "" && (pull_request.AuthorName != "" || pull_request.AuthorEmail != "") {
+		return nil, fmt.Errorf("INPUT_GPG_PRIVATE_KEY can't be used with INPUT_AUTHOR_NAME or INPUT_AUTHOR_EMAIL, " +
+			"the commits being authored by the user ID of the key")
+	}
+
+	// Get the number of times the commits are rebased and pushed again when the branch moved since it was fetched
+	if pull_request.PushRetries, err = getIntInput("INPUT_PUSH_RETRIES", pull_request.DefaultPushRetries); err != nil {
+		return nil, err
+	}
+
+	// Read the repository and create the commits with the API rather than git, i.e. without a checkout
+	writeMode := strings.TrimSpace(os.Getenv("INPUT_WRITE_MODE"))
+	switch writeMode {
+	case "":
+		writeMode = action.WriteModeGit
+	case action.WriteModeGit, action.WriteModeRemote:
+	default:
+		return nil, fmt.Errorf("INPUT_WRITE_MODE must be %s or %s, got %q", action.WriteModeGit,
+			action.WriteModeRemote, writeMode)
+	}
+	if writeMode == action.WriteModeRemote && (signedCommits || gitRemote != pull_request.DefaultRemote ||
+		pull_request.GPGPrivateKey != "" || pull_request.AuthorName != "" || pull_request.AuthorEmail != "" ||
+		pull_request.Signoff) {
+		return nil, fmt.Errorf("INPUT_WRITE_MODE %s can't be used with INPUT_SIGNED_COMMITS, INPUT_GIT_REMOTE, "+
+			"INPUT_FORK_OWNER, INPUT_GPG_PRIVATE_KEY, INPUT_AUTHOR_NAME, INPUT_AUTHOR_EMAIL or INPUT_SIGNOFF, the API "+
+			"commits being signed by GitHub and authored by the token", action.WriteModeRemote)
+	}
+
+	// The target repository is cloned unless the workspace is already a checkout of it or it isn't checked out at all
+	if cloneTarget && !otherRepository {
+		return nil, fmt.Errorf("INPUT_CLONE_TARGET_REPOSITORY requires INPUT_TARGET_REPOSITORY to be another repository")
+	}
+	if cloneTarget && writeMode == action.WriteModeRemote {
+		return nil, fmt.Errorf("INPUT_CLONE_TARGET_REPOSITORY can't be used with INPUT_WRITE_MODE %s, which doesn't "+
+			"need a checkout", action.WriteModeRemote)
+	}
+
+	if bools.get("INPUT_SKIP_CI", false) {
+		pull_request.SkipCIMarker = pull_request.DefaultSkipCIMarker
+		if marker := strings.TrimSpace(os.Getenv("INPUT_SKIP_CI_MARKER")); marker != "" {
+			pull_request.SkipCIMarker = marker
+		}
+	}
+	if actor := os.Getenv("GITHUB_ACTOR"); actor != "" && bools.get("INPUT_CO_AUTHOR", false) {
+		email := actor + "@users.noreply.github.com"
+		if id := os.Getenv("GITHUB_ACTOR_ID"); id != "" {
+			email = id + "+" + email
+		}
+		pull_request.CoAuthors = []string{fmt.Sprintf("%s <%s>", actor, email)}
+	}
+
+	// Get the algorithm of the digests the images are pinned with
+	digestAlgorithm := strings.TrimSpace(os.Getenv("INPUT_IMAGE_DIGEST_ALGORITHM"))
+	if digestAlgorithm == "" {
+		digestAlgorithm = action.DigestSHA256
+	}
+	if digestAlgorithm != action.DigestSHA256 && digestAlgorithm != action.DigestSHA512 {
+		return nil, fmt.Errorf("INPUT_IMAGE_DIGEST_ALGORITHM must be %s or %s, got %q",
+			action.DigestSHA256, action.DigestSHA512, digestAlgorithm)
+	}
+
+	// Get how the findings are organized in the job summary
+	summaryGroupBy := strings.TrimSpace(os.Getenv("INPUT_SUMMARY_GROUP_BY"))
+	switch summaryGroupBy {
+	case "":
+		summaryGroupBy = action.SummaryByFile
+	case action.SummaryByFile, action.SummaryByReference, action.SummaryByType:
+	default:
+		return nil, fmt.Errorf("INPUT_SUMMARY_GROUP_BY must be %s, %s or %s, got %q",
+			action.SummaryByFile, action.SummaryByReference, action.SummaryByType, summaryGroupBy)
+	}
+
+	// Get how the original tags of the pins are recorded
+	commentStyle := strings.TrimSpace(os.Getenv("INPUT_PINNING_COMMENT_STYLE"))
+	switch commentStyle {
+	case "":
+		commentStyle = action.CommentStyleInline
+	case action.CommentStyleInline, action.CommentStyleAbove, action.CommentStyleStructured:
+	default:
+		return nil, fmt.Errorf("INPUT_PINNING_COMMENT_STYLE must be %s, %s or %s, got %q",
+			action.CommentStyleInline, action.CommentStyleAbove, action.CommentStyleStructured, commentStyle)
+	}
+
+	// Get the input keys of the steps which are set to action references
+	nestedActionKeys := getListInput("INPUT_PIN_NESTED_ACTION_INPUTS")
+
+	// Read the pull request and branches of the run from the event payload, falling back to the environment
+	var event action.Event
+	if !otherRepository {
+		if event, err = action.ReadEvent(os.Getenv("GITHUB_EVENT_PATH")); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	if event.PRNumber != 0 {
+		log.Printf("Running on pull request #%d from %s to %s", event.PRNumber, event.HeadRef, event.BaseRef)
+	}
+	headSHA := event.HeadSHA
+	if headSHA == "" && !otherRepository {
+		headSHA = os.Getenv("GITHUB_SHA")
+	}
+	// The base branch of the pull request is the default branch of the repository unless set, which is read from the
+	// API when opening the pull request if it's not in the event payload
+	baseBranch := strings.TrimSpace(os.Getenv("INPUT_BASE_BRANCH"))
+	if baseBranch == "" {
+		baseBranch = event.DefaultBranch
+	}
+
+	// Get the branch to commit to when committing to the current branch, which must be a branch rather than a tag or
+	// the merge commit of a pull request. The current branch of another repository is its base branch
+	var currentBranch string
+	if otherRepository {
+		currentBranch = baseBranch
+	} else if os.Getenv("GITHUB_REF_TYPE") == "branch" && !strings.HasSuffix(os.Getenv("GITHUB_REF_NAME"), "/merge") {
+		currentBranch = os.Getenv("GITHUB_REF_NAME")
+	} else if event.PRNumber == 0 {
+		currentBranch = event.HeadRef
+	}
+
+	// Committing directly to the current branch is a shorthand for opening a pull request committed to it instead
+	commitDirect := bools.get("INPUT_COMMIT_DIRECT", false)
+
+	// Get the template of the messages of the commits of each category of changes
+	var commitTemplate *template.Template
+	if text := os.Getenv("INPUT_COMMIT_SCOPE_LABELS"); strings.TrimSpace(text) != "" {
+		if commitTemplate, err = action.ParseCommitScopeLabels(text); err != nil {
+			return nil, fmt.Errorf("invalid INPUT_COMMIT_SCOPE_LABELS: %w", err)
+		}
+	}
+
+	// Get the templates of the title and body of the pull request, the defaults being used if unset
+	var prTitle, prBody *template.Template
+	if text := os.Getenv("INPUT_PR_TITLE"); strings.TrimSpace(text) != "" {
+		if prTitle, err = action.ParseTemplate("title", text); err != nil {
+			return nil, fmt.Errorf("invalid INPUT_PR_TITLE: %w", err)
+		}
+	}
+	if text := os.Getenv("INPUT_PR_BODY"); strings.TrimSpace(text) != "" {
+		if prBody, err = action.ParseTemplate("body", text); err != nil {
+			return nil, fmt.Errorf("invalid INPUT_PR_BODY: %w", err)
+		}
+	}
+
+	// Fill the pull request template of the repository with the default body, which sets the whole body otherwise
+	usePRTemplate := bools.get("INPUT_USE_PR_TEMPLATE", false)
+	if usePRTemplate && prBody != nil {
+		return nil, fmt.Errorf("INPUT_USE_PR_TEMPLATE can't be used with INPUT_PR_BODY")
+	}
+
+	// Get the template of the message of the commit with all the changes, the default message being used if unset
+	var commitMessage *template.Template
+	if text := os.Getenv("INPUT_COMMIT_MESSAGE"); strings.TrimSpace(text) != "" {
+		if commitMessage, err = action.ParseCommitMessageTemplate(text); err != nil {
+			return nil, fmt.Errorf("invalid INPUT_COMMIT_MESSAGE: %w", err)
+		}
+	}
+
+	// Get the conventional commit type and scope of the default commit subjects, i.e. chore(deps)
+	commitType := strings.TrimSpace(os.Getenv("INPUT_COMMIT_TYPE"))
+	commitScope := strings.TrimSpace(os.Getenv("INPUT_COMMIT_SCOPE"))
+	if err := action.ValidateCommitType(commitType, commitScope); err != nil {
+		return nil, fmt.Errorf("invalid INPUT_COMMIT_TYPE or INPUT_COMMIT_SCOPE: %w", err)
+	}
+	if commitType != "" && (commitMessage != nil || commitTemplate != nil) {
+		return nil, fmt.Errorf("INPUT_COMMIT_TYPE can't be used with INPUT_COMMIT_MESSAGE or INPUT_COMMIT_SCOPE_LABELS, " +
+			"which set the whole commit message")
+	}
+
+	// Get the template of the name of the branch of the pull request, i.e. frizbee/pin-{{.RunID}}
+	var branch *template.Template
+	if text := strings.TrimSpace(os.Getenv("INPUT_BRANCH")); text != "" {
+		if branch, err = action.ParseTemplate("branch", text); err != nil {
+			return nil, fmt.Errorf("invalid INPUT_BRANCH: %w", err)
+		}
+	}
+
+	// Get the templates of the path and content of the changelog fragment added to the changes, i.e.
+	// changelog.d/frizbee-{{.Date}}.md, the default content listing the pins
+	var changelogFragment, changelogFragmentBody *template.Template
+	if text := strings.TrimSpace(os.Getenv("INPUT_CHANGELOG_FRAGMENT")); text != "" {
+		if changelogFragment, err = action.ParseTemplate("changelog fragment", text); err != nil {
+			return nil, fmt.Errorf("invalid INPUT_CHANGELOG_FRAGMENT: %w", err)
+		}
+		text = os.Getenv("INPUT_CHANGELOG_FRAGMENT_BODY")
+		if strings.TrimSpace(text) == "" {
+			text = action.DefaultChangelogFragment
+		}
+		if changelogFragmentBody, err = action.ParseTemplate("changelog fragment body", text); err != nil {
+			return nil, fmt.Errorf("invalid INPUT_CHANGELOG_FRAGMENT_BODY: %w", err)
+		}
+	}
+
+	// Get how the changes are split into commits, the commit scope labels splitting them by category unless set
+	commitGranularity := strings.TrimSpace(os.Getenv("INPUT_COMMIT_GRANULARITY"))
+	switch commitGranularity {
+	case "":
+		commitGranularity = action.CommitGranularityAll
+	case action.CommitGranularityAll, action.CommitGranularityPerFile, action.CommitGranularityPerDependency:
+	default:
+		return nil, fmt.Errorf("INPUT_COMMIT_GRANULARITY must be %s, %s or %s, got %q", action.CommitGranularityAll,
+			action.CommitGranularityPerFile, action.CommitGranularityPerDependency, commitGranularity)
+	}
+
+	// Get the suffix of the branch of the pull request, keeping the concurrent runs from pushing to the same branch
+	branchSuffix := strings.TrimSpace(os.Getenv("INPUT_BRANCH_SUFFIX"))
+	switch branchSuffix {
+	case "", "none":
+		branchSuffix = ""
+	case action.BranchSuffixRunID, action.BranchSuffixContentHash:
+	default:
+		return nil, fmt.Errorf("INPUT_BRANCH_SUFFIX must be none, %s or %s, got %q", action.BranchSuffixRunID,
+			action.BranchSuffixContentHash, branchSuffix)
+	}
+	if branchSuffix == action.BranchSuffixRunID && os.Getenv("GITHUB_RUN_ID") == "" {
+		return nil, fmt.Errorf("INPUT_BRANCH_SUFFIX %s requires GITHUB_RUN_ID", action.BranchSuffixRunID)
+	}
+
+	// Get how the branch of the pull request is brought up to date with the base branch when it's behind
+	updateBranch := strings.TrimSpace(os.Getenv("INPUT_UPDATE_BRANCH"))
+	switch updateBranch {
+	case "", "none":
+		updateBranch = ""
+	case action.UpdateBranchMerge, action.UpdateBranchRebase:
+	default:
+		return nil, fmt.Errorf("INPUT_UPDATE_BRANCH must be none, %s or %s, got %q", action.UpdateBranchMerge,
+			action.UpdateBranchRebase, updateBranch)
+	}
+
+	// Get whether a pull request is opened for each category of the changes, or each directory of the changed files
+	splitPRs := strings.TrimSpace(os.Getenv("INPUT_SPLIT_PRS"))
+	switch splitPRs {
+	case "", "none":
+		splitPRs = ""
+	case action.SplitPRsCategory:
+	default:
+		return nil, fmt.Errorf("INPUT_SPLIT_PRS must be none or %s, got %q", action.SplitPRsCategory, splitPRs)
+	}
+	switch groupBy := strings.TrimSpace(os.Getenv("INPUT_GROUP_BY")); groupBy {
+	case "", "none":
+	case action.SplitPRsDirectory:
+		if splitPRs != "" {
+			return nil, fmt.Errorf("INPUT_GROUP_BY can't be used with INPUT_SPLIT_PRS")
+		}
+		splitPRs = groupBy
+	default:
+		return nil, fmt.Errorf("INPUT_GROUP_BY must be none or %s, got %q", action.SplitPRsDirectory, groupBy)
+	}
+	groupDepth, err := getIntInput("INPUT_GROUP_DEPTH", action.DefaultGroupDepth)
+	if err != nil {
+		return nil, err
+	}
+	if groupDepth == 0 {
+		return nil, fmt.Errorf("INPUT_GROUP_DEPTH must be at least 1")
+	}
+	maxChangesPerPR, err := getIntInput("INPUT_MAX_CHANGES_PER_PR", 0)
+	if err != nil {
+		return nil, err
+	}
+	if maxChangesPerPR > 0 {
+		if splitPRs != "" {
+			return nil, fmt.Errorf("INPUT_MAX_CHANGES_PER_PR can't be used with INPUT_SPLIT_PRS or INPUT_GROUP_BY")
+		}
+		splitPRs = action.SplitPRsBatch
+	}
+	switch {
+	case splitPRs == "":
+	case commitDirect || bools.get("INPUT_COMMIT_TO_CURRENT_BRANCH", false):
+		return nil, fmt.Errorf("INPUT_SPLIT_PRS, INPUT_GROUP_BY and INPUT_MAX_CHANGES_PER_PR can't be used with " +
+			"INPUT_COMMIT_DIRECT or INPUT_COMMIT_TO_CURRENT_BRANCH, as no pull request is opened")
+	}
+
+	// Get the project the pull request is added to, if any
+	var project *action.Project
+	if url := strings.TrimSpace(os.Getenv("INPUT_PROJECT")); url != "" {
+		if project, err = action.ParseProject(url); err != nil {
+			return nil, fmt.Errorf("invalid INPUT_PROJECT: %w", err)
+		}
+	}
+
+	// Get the patterns matching the image references of shell scripts, one per line, if they are scanned
+	var shellScriptRegex *regexp.Regexp
+	shellScriptsPath := os.Getenv("INPUT_SHELL_SCRIPTS")
+	if shellScriptsPath != "" {
+		var patterns []string
+		for _, pattern := range strings.Split(os.Getenv("INPUT_SHELL_SCRIPT_PATTERNS"), "\n") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+		shellScriptRegex, err = action.ShellScriptRegex(patterns)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INPUT_SHELL_SCRIPT_PATTERNS: %w", err)
+		}
+	}
+
+	// Get the commit to scan, the checked out commit being scanned by default
+	ref := strings.TrimSpace(os.Getenv("INPUT_REF"))
+	if ref != "" && os.Getenv("INPUT_APPLY_FROM_FILE") != "" {
+		return nil, fmt.Errorf("INPUT_REF can't be used with INPUT_APPLY_FROM_FILE")
+	}
+
+	// Get the baseline of the accepted findings
+	baselineFile := os.Getenv("INPUT_BASELINE_FILE")
+	writeBaseline := bools.get("INPUT_WRITE_BASELINE", false)
+	if writeBaseline && baselineFile == "" {
+		return nil, fmt.Errorf("INPUT_WRITE_BASELINE requires INPUT_BASELINE_FILE to be set")
+	}
+
+	// The results bundle directory is cleared before writing to it, so it can't hold the repository
+	resultArtifactDir := os.Getenv("INPUT_RESULT_ARTIFACT_PATH")
+	if resultArtifactDir != "" {
+		dir, err := filepath.Abs(resultArtifactDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INPUT_RESULT_ARTIFACT_PATH: %w", err)
+		}
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(dir, wd); err == nil && filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("INPUT_RESULT_ARTIFACT_PATH can't be the repository or one of its parents")
+		}
+	}
+
+	// Stop at the first unpinned reference, which leaves nothing to report on or apply
+	haltOnFirstUnpinned := bools.get("INPUT_HALT_ON_FIRST_UNPINNED", false)
+	if haltOnFirstUnpinned {
+		for _, name := range []string{
+			"INPUT_OPEN_PR", "INPUT_COMMIT_DIRECT", "INPUT_TRANSFORM_ONLY", "INPUT_WRITE_BASELINE",
+			"INPUT_OUTPUT_JSON_STDOUT", "INPUT_ANNOTATE_PR_CHECK",
+		} {
+			if bools.get(name, false) {
+				return nil, fmt.Errorf("INPUT_HALT_ON_FIRST_UNPINNED can't be used with %s", name)
+			}
+		}
+		for _, name := range []string{"INPUT_APPLY_FROM_FILE", "INPUT_RESULT_CALLBACK_FILE", "INPUT_RESULT_ARTIFACT_PATH"} {
+			if os.Getenv(name) != "" {
+				return nil, fmt.Errorf("INPUT_HALT_ON_FIRST_UNPINNED can't be used with %s", name)
+			}
+		}
+	}
+
+	// Keep the standard output clean for the JSON report, logging everything else to the standard error
+	jsonStdout := bools.get("INPUT_OUTPUT_JSON_STDOUT", false)
+	if jsonStdout {
+		log.SetOutput(os.Stderr)
+		pull_request.Stdout = os.Stderr
+	}
+
 	// Read the action settings from the environment and create the new frizbee replacers for actions and images
-	return &action.FrizbeeAction{
-		Client:            github.NewClient(tc),
-		RepoOwner:         repoOwner,
-		RepoName:          strings.TrimPrefix(repoFullName, repoOwner+"/"),
-		ActionsPath:       os.Getenv("INPUT_ACTIONS"),
-		DockerfilesPath:   os.Getenv("INPUT_DOCKERFILES"),
-		KubernetesPath:    os.Getenv("INPUT_KUBERNETES"),
-		DockerComposePath: os.Getenv("INPUT_DOCKER_COMPOSE"),
-		OpenPR:            os.Getenv("INPUT_OPEN_PR") == "true",
-		FailOnUnpinned:    os.Getenv("INPUT_FAIL_ON_UNPINNED") == "true",
-		ActionsReplacer:   replacer.NewGitHubActionsReplacer(&config.Config{}).WithGitHubClientFromToken(token),
-		ImagesReplacer:    replacer.NewContainerImagesReplacer(&config.Config{}),
-	}, nil
+	fa := &action.FrizbeeAction{
+		Client:                client,
+		RepoOwner:             repoOwner,
+		RepoName:              strings.TrimPrefix(repoFullName, repoOwner+"/"),
+		CloneRepository:       cloneTarget,
+		ActionsPath:           os.Getenv("INPUT_ACTIONS"),
+		DockerfilesPath:       os.Getenv("INPUT_DOCKERFILES"),
+		KubernetesPath:        os.Getenv("INPUT_KUBERNETES"),
+		DockerComposePath:     os.Getenv("INPUT_DOCKER_COMPOSE"),
+		EnvFile:               os.Getenv("INPUT_ENV_FILE"),
+		DevcontainerPath:      os.Getenv("INPUT_DEVCONTAINER"),
+		QuadletPath:           os.Getenv("INPUT_QUADLET"),
+		HelmPath:              os.Getenv("INPUT_HELM"),
+		TerraformPath:         os.Getenv("INPUT_TERRAFORM"),
+		GitLabCIPath:          os.Getenv("INPUT_GITLAB_CI"),
+		CircleCIPath:          os.Getenv("INPUT_CIRCLECI"),
+		CircleCIPinOrbs:       bools.get("INPUT_CIRCLECI_PIN_ORBS", false),
+		AzurePipelinesPath:    os.Getenv("INPUT_AZURE_PIPELINES"),
+		BitbucketPath:         os.Getenv("INPUT_BITBUCKET_PIPELINES"),
+		TektonPath:            os.Getenv("INPUT_TEKTON"),
+		ShellScriptsPath:      shellScriptsPath,
+		ShellScriptRegex:      shellScriptRegex,
+		MaxFiles:              maxFiles,
+		TransformOnly:         bools.get("INPUT_TRANSFORM_ONLY", false),
+		OutputDir:             os.Getenv("INPUT_OUTPUT_DIR"),
+		Ref:                   ref,
+		Workdir:               os.Getenv("INPUT_WORKDIR"),
+		OpenPR:                bools.get("INPUT_OPEN_PR", false) || commitDirect,
+		FailOnUnpinned:        bools.get("INPUT_FAIL_ON_UNPINNED", false),
+		HaltOnFirstUnpinned:   haltOnFirstUnpinned,
+		DiffContext:           diffContext,
+		UseLocalDaemon:        bools.get("INPUT_USE_LOCAL_DAEMON", false),
+		AnnotatePRCheck:       bools.get("INPUT_ANNOTATE_PR_CHECK", false),
+		HeadSHA:               headSHA,
+		PRNumber:              event.PRNumber,
+		BaseBranch:            baseBranch,
+		HeadRef:               event.HeadRef,
+		PreApplyHook:          os.Getenv("INPUT_PRE_APPLY_HOOK"),
+		PostApplyHook:         os.Getenv("INPUT_POST_APPLY_HOOK"),
+		ResultCallbackFile:    os.Getenv("INPUT_RESULT_CALLBACK_FILE"),
+		ApplyFromFile:         os.Getenv("INPUT_APPLY_FROM_FILE"),
+		CommitTemplate:        commitTemplate,
+		CommitGranularity:     commitGranularity,
+		CommitMessageTemplate: commitMessage,
+		CommitType:            commitType,
+		CommitScope:           commitScope,
+		SplitPRs:              splitPRs,
+		GroupDepth:            groupDepth,
+		MaxChangesPerPR:       maxChangesPerPR,
+		BranchSuffix:          branchSuffix,
+		UpdateBranch:          updateBranch,
+		SignedCommits:         signedCommits,
+		WriteMode:             writeMode,
+		PushFallback:          bools.get("INPUT_PUSH_FALLBACK", false),
+		LockTimeout:           lockTimeout,
+		ForcePush:             bools.get("INPUT_FORCE_PUSH", false),
+		PRTitleTemplate:       prTitle,
+		PRBodyTemplate:        prBody,
+		UsePRTemplate:         usePRTemplate,
+		PRDraft:               bools.get("INPUT_PR_DRAFT", false),
+		PRLabels:              getListInput("INPUT_PR_LABELS"),
+		PRReviewers:           getListInput("INPUT_REVIEWERS"),
+		PRTeamReviewers:       getListInput("INPUT_TEAM_REVIEWERS"),
+		PRAssignees:           getListInput("INPUT_ASSIGNEES"),
+		CodeownersReviewers:   bools.get("INPUT_CODEOWNERS_REVIEWERS", false),
+		PRMilestone:           strings.TrimSpace(os.Getenv("INPUT_MILESTONE")),
+		PRProject:             project,
+		CloseSuperseded:       bools.get("INPUT_CLOSE_SUPERSEDED_PRS", false),
+		StaleBranchPrefix:     strings.TrimSpace(os.Getenv("INPUT_DELETE_STALE_BRANCHES")),
+		BranchTemplate:        branch,
+		ChangelogFragment:     changelogFragment,
+		ChangelogFragmentBody: changelogFragmentBody,
+		RunID:                 os.Getenv("GITHUB_RUN_ID"),
+		DryRunExitZero:        bools.get("INPUT_DRY_RUN_EXIT_ZERO", false),
+		ResultArtifactDir:     resultArtifactDir,
+		ProvenanceFooter:      bools.get("INPUT_PROVENANCE_FOOTER", false),
+		OnlyPathsFile:         os.Getenv("INPUT_ONLY_PATHS_FROM_FILE"),
+		AllowEmptyPaths:       bools.get("INPUT_ALLOW_EMPTY_PATHS", false),
+		FailOnError:           bools.get("INPUT_FAIL_ON_ERROR", false),
+		FailOnUnresolved:      bools.get("INPUT_FAIL_ON_UNRESOLVED", false),
+		ResolveErrors:         resolveErrors,
+		GitRemote:             gitRemote,
+		TimeoutPerFile:        timeoutPerFile,
+		ConfirmDigests:        bools.get("INPUT_CONFIRM_DIGEST_IMMUTABILITY", false),
+		VerifySigstore:        bools.get("INPUT_VERIFY_SIGSTORE", false),
+		SigstoreIdentity:      os.Getenv("INPUT_SIGSTORE_CERTIFICATE_IDENTITY"),
+		SigstoreIssuer:        os.Getenv("INPUT_SIGSTORE_CERTIFICATE_OIDC_ISSUER"),
+		FollowSymlinks:        bools.get("INPUT_FOLLOW_SYMLINKS", true),
+		JSONStdout:            jsonStdout,
+		BaselineFile:          baselineFile,
+		WriteBaseline:         writeBaseline,
+		PRChecklist:           bools.get("INPUT_PR_UPDATE_BODY_WITH_CHECKLIST", false),
+		DigestAlgorithm:       digestAlgorithm,
+		DigestPinTagless:      bools.get("INPUT_DIGEST_PIN_TAGLESS", false),
+		CommentStyle:          commentStyle,
+		DedupeFindings:        bools.get("INPUT_DEDUPE_FINDINGS", false),
+		NoticeOnSuccess:       bools.get("INPUT_NOTICE_ON_SUCCESS", false),
+		NestedActionKeys:      nestedActionKeys,
+		SummaryGroupBy:        summaryGroupBy,
+		SummaryIncludeDiff:    bools.get("INPUT_SUMMARY_INCLUDE_DIFF", false),
+		RefreshPins:           bools.get("INPUT_REFRESH_PINS", false),
+		IgnoreUnresolvable:    bools.get("INPUT_IGNORE_UNRESOLVABLE", false),
+		CommitToCurrentBranch: bools.get("INPUT_COMMIT_TO_CURRENT_BRANCH", false) || commitDirect,
+		CurrentBranch:         currentBranch,
+		Tracer:                tracer,
+		ActionsReplacer:       actionsReplacer,
+		ImagesReplacer:        replacer.NewContainerImagesReplacer(&config.Config{}),
+	}
+	if bools.err != nil {
+		return nil, bools.err
+	}
+	return fa, nil
+}
+
+// boolInputs reads the boolean inputs from the environment, recording the first invalid one
+type boolInputs struct {
+	err error
+}
+
+// get reads a boolean input, accepting the values of strconv.ParseBool in any case and surrounded by spaces, i.e.
+// ` True `. It returns the default value if the input is not set or invalid, recording the error in the latter case
+func (b *boolInputs) get(name string, defaultValue bool) bool {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv(name)))
+	if value == "" {
+		return defaultValue
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		if b.err == nil {
+			b.err = fmt.Errorf("%s must be true or false, got %q", name, os.Getenv(name))
+		}
+		return defaultValue
+	}
+	return v
+}
+
+// getIntInput reads a non-negative integer input from the environment, returning the default value if it is not set
+func getIntInput(name string, defaultValue int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %q", name, value)
+	}
+	return i, nil
+}
+
+// getListInput reads a comma-separated list input from the environment, dropping the empty items
+func getListInput(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -17,140 +17,907 @@ package action
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/google/go-github/v60/github"
 	"github.com/stacklok/frizbee-action/pkg/pull_request"
 	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/replacer/image"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 )
 
+// usesKeyRegex matches lines declaring the `uses:` key of a workflow job, workflow step or composite action step
+var usesKeyRegex = regexp.MustCompile(`^\s*(-\s+)?uses:\s*`)
+
 type FrizbeeAction struct {
-	Client            *github.Client
-	RepoOwner         string
-	RepoName          string
-	ActionsPath       string
-	DockerfilesPath   string
-	KubernetesPath    string
-	DockerComposePath string
-	OpenPR            bool
-	FailOnUnpinned    bool
-	ActionsReplacer   *replacer.Replacer
-	ImagesReplacer    *replacer.Replacer
+	Client                *github.Client
+	RepoOwner             string
+	RepoName              string
+	ActionsPath           string
+	DockerfilesPath       string
+	KubernetesPath        string
+	DockerComposePath     string
+	EnvFile               string
+	DevcontainerPath      string
+	QuadletPath           string
+	HelmPath              string
+	TerraformPath         string
+	GitLabCIPath          string
+	CircleCIPath          string
+	CircleCIPinOrbs       bool
+	AzurePipelinesPath    string
+	BitbucketPath         string
+	TektonPath            string
+	ShellScriptsPath      string
+	ShellScriptRegex      *regexp.Regexp
+	MaxFiles              int
+	TransformOnly         bool
+	OutputDir             string
+	Workdir               string
+	Ref                   string
+	OpenPR                bool
+	FailOnUnpinned        bool
+	HaltOnFirstUnpinned   bool
+	DiffContext           int
+	UseLocalDaemon        bool
+	AnnotatePRCheck       bool
+	HeadSHA               string
+	PRNumber              int
+	BaseBranch            string
+	HeadRef               string
+	PreApplyHook          string
+	PostApplyHook         string
+	ResultCallbackFile    string
+	ApplyFromFile         string
+	CommitTemplate        *template.Template
+	CommitGranularity     string
+	CommitMessageTemplate *template.Template
+	CommitType            string
+	CommitScope           string
+	SplitPRs              string
+	GroupDepth            int
+	MaxChangesPerPR       int
+	BranchSuffix          string
+	UpdateBranch          string
+	SignedCommits         bool
+	WriteMode             string
+	CloneRepository       bool
+	PushFallback          bool
+	LockTimeout           time.Duration
+	ForcePush             bool
+	PRTitleTemplate       *template.Template
+	PRBodyTemplate        *template.Template
+	PRDraft               bool
+	UsePRTemplate         bool
+	PRLabels              []string
+	PRReviewers           []string
+	PRTeamReviewers       []string
+	PRAssignees           []string
+	CodeownersReviewers   bool
+	PRMilestone           string
+	PRProject             *Project
+	CloseSuperseded       bool
+	StaleBranchPrefix     string
+	BranchTemplate        *template.Template
+	ChangelogFragment     *template.Template
+	ChangelogFragmentBody *template.Template
+	RunID                 string
+	DryRunExitZero        bool
+	ResultArtifactDir     string
+	ProvenanceFooter      bool
+	OnlyPathsFile         string
+	AllowEmptyPaths       bool
+	FailOnError           bool
+	FailOnUnresolved      bool
+	ResolveErrors         *ResolveErrors
+	GitRemote             string
+	TimeoutPerFile        time.Duration
+	ConfirmDigests        bool
+	VerifySigstore        bool
+	SigstoreIdentity      string
+	SigstoreIssuer        string
+	FollowSymlinks        bool
+	JSONStdout            bool
+	BaselineFile          string
+	WriteBaseline         bool
+	PRChecklist           bool
+	DigestAlgorithm       string
+	DigestPinTagless      bool
+	CommentStyle          string
+	DedupeFindings        bool
+	NoticeOnSuccess       bool
+	SummaryGroupBy        string
+	SummaryIncludeDiff    bool
+	RefreshPins           bool
+	IgnoreUnresolvable    bool
+	CommitToCurrentBranch bool
+	CurrentBranch         string
+	Tracer                *Tracer
+	NestedActionKeys      []string
+	ActionsReplacer       *replacer.Replacer
+	ImagesReplacer        *replacer.Replacer
+
+	daemon         *daemonClient
+	findings       []Finding
+	refreshed      []Finding
+	changes        []fileChange
+	processedFiles []string
+	modifiedFiles  []string
+	skippedFiles   []string
+	processedSeen  map[string]bool
+	modifiedSeen   map[string]bool
+	digests        map[string]string
+	artifacts      map[string]string
+	signatures     map[string]string
+	pullRequest    *github.PullRequest
+	pullRequests   []*github.PullRequest
+	markers        []PullRequestMarker
+	branch         string
+	group          pullRequestGroup
+	snapshotSHA    string
+	env            map[string]string
+	alreadyPinned  map[string]int
+	orbMu          sync.Mutex
+	orbCache       map[string][]string
 }
 
 // Run runs the frizbee action
 func (fa *FrizbeeAction) Run(ctx context.Context) error {
+	// Scan a snapshot of the repository downloaded with the API if it isn't checked out, or a clone of it if requested,
+	// i.e. when running against another repository. The commit of the given ref is scanned rather than the working
+	// tree if set
+	if fa.CloneRepository {
+		restore, err := fa.cloneRepository(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to clone the repository: %w", err)
+		}
+		defer restore()
+	}
+	if fa.WriteMode == WriteModeRemote {
+		restore, err := fa.downloadSnapshot(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to download the repository: %w", err)
+		}
+		defer restore()
+	} else if fa.Ref != "" {
+		restore, err := fa.checkoutRef()
+		if err != nil {
+			return fmt.Errorf("failed to check out %s: %w", fa.Ref, err)
+		}
+		defer restore()
+	}
+
+	// Apply the changes of a previous run instead of scanning if requested, writing the changes for a later run
+	// otherwise
+	var modified bool
+	var err error
+	if fa.ApplyFromFile != "" {
+		modified, err = fa.applyHandoff()
+		if err != nil {
+			return err
+		}
+	} else {
+		modified, err = fa.scan(ctx)
+		if err != nil {
+			return err
+		}
+		if fa.ResultCallbackFile != "" {
+			if err := fa.writeHandoff(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Only report the findings which are not accepted in the baseline, writing it first if requested. All the
+	// references are pinned regardless
+	pinned := fa.findings
+	if fa.WriteBaseline {
+		if err := fa.writeBaseline(); err != nil {
+			return err
+		}
+	}
+	if fa.BaselineFile != "" {
+		if err := fa.applyBaseline(); err != nil {
+			return err
+		}
+	}
+
+	// If the OpenPR flag is set, commit and push the changes and create a pull request. The TransformOnly flag
+	// disables any git and GitHub write operation
+	if fa.OpenPR && modified && !fa.TransformOnly {
+		// Keep the concurrent runs from pushing at the same time
+		if fa.LockTimeout > 0 {
+			release, err := fa.acquireLock(ctx)
+			if err != nil {
+				return err
+			}
+			defer release()
+		}
+		// Run the pre-apply hook over the written files before committing them
+		if fa.PreApplyHook != "" {
+			log.Printf("Running pre-apply hook: %s", fa.PreApplyHook)
+			if err := pull_request.RunHook(fa.PreApplyHook, fa.hookEnv()); err != nil {
+				return fmt.Errorf("pre-apply hook failed, not applying the changes: %w", err)
+			}
+		}
+		// Run the post-apply hook as the final gate over the files about to be committed, after the pre-apply hook
+		if fa.PostApplyHook != "" {
+			log.Printf("Running post-apply hook: %s", fa.PostApplyHook)
+			if err := pull_request.RunHook(fa.PostApplyHook, fa.hookEnv()); err != nil {
+				return fmt.Errorf("post-apply hook failed, not committing the changes: %w", err)
+			}
+		}
+		// Commit the changes of each file or dependency separately if requested, or of each category if a commit
+		// message template is set, which also renders the messages of the commits of each file or dependency
+		message, err := fa.commitMessage(pinned)
+		if err != nil {
+			return err
+		}
+		commits := []pull_request.Commit{{Message: fa.withProvenance(message)}}
+		switch {
+		case fa.CommitGranularity == CommitGranularityPerFile:
+			commits, err = fa.fileCommits(append(slices.Clone(pinned), fa.refreshed...))
+		case fa.CommitGranularity == CommitGranularityPerDependency:
+			commits, err = fa.dependencyCommits(append(slices.Clone(pinned), fa.refreshed...))
+		case fa.CommitTemplate != nil:
+			commits, err = fa.scopedCommits(append(slices.Clone(pinned), fa.refreshed...))
+		}
+		if err != nil {
+			return err
+		}
+		if commits, err = fa.withChangelogFragment(pinned, commits); err != nil {
+			return err
+		}
+		if fa.CommitToCurrentBranch {
+			// Commit the changes inline, without opening a PR
+			if err := fa.checkBranchUnprotected(ctx); err != nil {
+				return err
+			}
+			switch {
+			case fa.WriteMode == WriteModeRemote:
+				err = fa.pushRemoteCommits(ctx, commits, fa.CurrentBranch, false)
+			case fa.SignedCommits:
+				err = fa.pushSignedCommits(ctx, commits, fa.CurrentBranch, false)
+			default:
+				err = fa.pushWithFallback(ctx, commits, fa.CurrentBranch, false, func() error {
+					return pull_request.CommitSeriesAndPushCurrentBranch(commits, fa.GitRemote, fa.CurrentBranch)
+				})
+			}
+			if err != nil {
+				return fmt.Errorf("failed to commit and push the changes: %w", err)
+			}
+		} else {
+			fa.resolveBaseBranch(ctx)
+			if fa.SplitPRs != "" {
+				err = fa.pushGroupPullRequests(ctx, pinned)
+			} else {
+				err = fa.pushPullRequest(ctx, pinned, commits)
+			}
+			if err != nil {
+				return err
+			}
+			if fa.CloseSuperseded {
+				if err := fa.closeSupersededPullRequests(ctx); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Delete the branches of the pull requests which were merged or closed since, whether anything was pinned or not
+	if fa.OpenPR && fa.StaleBranchPrefix != "" && !fa.CommitToCurrentBranch && !fa.TransformOnly {
+		if err := fa.deleteStaleBranches(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Write the results bundle
+	if fa.ResultArtifactDir != "" {
+		if err := fa.writeResultArtifact(fa.ResultArtifactDir); err != nil {
+			return fmt.Errorf("failed to write result artifact: %w", err)
+		}
+	}
+
+	// Summarize the findings in the job summary
+	if err := fa.writeStepSummary(); err != nil {
+		return err
+	}
+
+	// Set the outputs of the action
+	if err := fa.writeOutputs(); err != nil {
+		return err
+	}
+
+	// Confirm a clean run with an annotation, if requested
+	if fa.NoticeOnSuccess && fa.clean() {
+		fa.notice("Frizbee", fmt.Sprintf("No unpinned references found in %d files", len(fa.processedFiles)))
+	}
+
+	// Publish a check run summarizing the findings
+	if fa.AnnotatePRCheck && !fa.TransformOnly {
+		if err := fa.publishCheckRun(ctx); err != nil {
+			return fmt.Errorf("failed to publish check run: %w", err)
+		}
+	}
+
+	// Explain how each reference was resolved
+	fa.explain()
+
+	// Print the report for piping it to other tools
+	if fa.JSONStdout {
+		if err := fa.printReport(); err != nil {
+			return fmt.Errorf("failed to print report: %w", err)
+		}
+	}
+
+	// Fail if any reference couldn't be resolved and the action is set to fail on errors
+	errs := fa.ResolveErrors.list()
+	if !fa.IgnoreUnresolvable {
+		for _, ref := range fa.ResolveErrors.listUnresolvable() {
+			errs = append(errs, fmt.Sprintf("%s: not found", ref))
+		}
+	}
+	if fa.FailOnError && len(errs) > 0 {
+		return fmt.Errorf("failed to resolve %d references:\n%s", len(errs), strings.Join(errs, "\n"))
+	}
+
+	// Exit with ErrUnresolvedFound error if any reference couldn't be resolved and the action is set to fail on
+	// unresolved references, along with ErrUnpinnedFound if unpinned references were found too
+	var unresolvedErr error
+	if fa.FailOnUnresolved && len(errs) > 0 {
+		unresolvedErr = fmt.Errorf("%w, %d references:\n%s", ErrUnresolvedFound, len(errs), strings.Join(errs, "\n"))
+	}
+
+	// Exit with ErrUnpinnedFound error if any unpinned references were found and the action is set to fail on
+	// unpinned, unless this is a dry run which is set to always exit zero
+	if fa.FailOnUnpinned && len(fa.findings) > 0 && !(fa.isDryRun() && fa.DryRunExitZero) {
+		return errors.Join(unresolvedErr, ErrUnpinnedFound)
+	}
+
+	return unresolvedErr
+}
+
+// scan parses all the target files, pinning their references. It returns whether any file was modified
+func (fa *FrizbeeAction) scan(ctx context.Context) (bool, error) {
+	if err := fa.checkSigstorePolicy(); err != nil {
+		return false, err
+	}
+
+	// Resolve the images from the local daemon first if requested and available
+	if fa.UseLocalDaemon {
+		fa.daemon = newDaemonClient(ctx)
+	}
+
+	// Get the paths to parse, either from the path inputs or from the paths file
+	t := fa.inputTargets()
+	if fa.OnlyPathsFile != "" {
+		var err error
+		t, err = fa.fileTargets()
+		if err != nil {
+			return false, fmt.Errorf("failed to read paths from %s: %w", fa.OnlyPathsFile, err)
+		}
+	}
+
+	// Guard against runaway scans
+	if err := fa.checkFileCount(t); err != nil {
+		return false, err
+	}
+
+	// Parse the files one at a time to stop at the first one with an unpinned reference
+	if fa.HaltOnFirstUnpinned {
+		var err error
+		if t, err = t.files(); err != nil {
+			return false, err
+		}
+	}
+
+	// Load the variables the Compose images are interpolated with
+	if fa.EnvFile != "" {
+		env, err := loadEnvFile(fa.workdirPath(fa.EnvFile))
+		if err != nil {
+			return false, err
+		}
+		fa.env = env
+	}
+
 	// Parse the workflow files
-	modified, err := fa.parseWorkflowActions(ctx)
+	modified, err := fa.parseWorkflowActions(ctx, t.actions)
 	if err != nil {
-		return fmt.Errorf("failed to parse workflow files: %w", err)
+		return false, fmt.Errorf("failed to parse workflow files: %w", err)
 	}
 
 	// Parse all yaml/yml files referencing container images
-	m, err := fa.parseImages(ctx)
+	m, err := fa.parseImages(ctx, t.images, t.walkers)
 	if err != nil {
-		return fmt.Errorf("failed to parse image files: %w", err)
+		return false, fmt.Errorf("failed to parse image files: %w", err)
+	}
+
+	// Report the Helm chart dependencies which are not pinned
+	if fa.HelmPath != "" {
+		if err := fa.parseHelmCharts(fa.workdirPath(fa.HelmPath)); err != nil {
+			return false, fmt.Errorf("failed to parse Helm charts: %w", err)
+		}
+		if err := fa.haltOnUnpinned(); err != nil {
+			return false, err
+		}
 	}
 
 	// Set the modified flag to true if any file was modified
 	modified = modified || m
 
-	// If the OpenPR flag is set, commit and push the changes and create a pull request
-	if fa.OpenPR && modified {
-		// TODO: use the git library to commit and push changes
-		// TODO: perhaps refactor the code so instead of having 1 commit, we have separate commits for each file that
-		// TODO: frizbee modified
-		pull_request.CommitAndPush()
-		// TODO: the default action token does not have permissions to open PRs against workflows in '.github/workflows/
-		// TODO: We need to use a PAT or something else to fix this
-		pull_request.CreatePullRequest()
-	}
-
-	// Exit with ErrUnpinnedFound error if any files were modified and the action is set to fail on unpinned
-	if fa.FailOnUnpinned && modified {
-		return ErrUnpinnedFound
+	// Report the references which don't exist or can't be seen with the provided credentials
+	for _, ref := range fa.ResolveErrors.listUnresolvable() {
+		if fa.IgnoreUnresolvable {
+			log.Printf("Ignoring %s: it can't be resolved", ref)
+		} else {
+			log.Printf("Warning: %s can't be resolved, it doesn't exist or can't be accessed", ref)
+		}
 	}
 
-	return nil
+	return modified, nil
 }
 
 // parseWorkflowActions parses the GitHub Actions workflow files and updates the modified files if the OpenPR flag is set
-func (fa *FrizbeeAction) parseWorkflowActions(ctx context.Context) (bool, error) {
-	if fa.ActionsPath == "" {
+func (fa *FrizbeeAction) parseWorkflowActions(ctx context.Context, paths []string) (bool, error) {
+	if len(paths) == 0 {
 		log.Printf("Workflow path is empty")
 		return false, nil
 	}
 
-	log.Printf("Parsing workflow files in %s...", fa.ActionsPath)
-	res, err := fa.ActionsReplacer.ParsePath(ctx, fa.ActionsPath)
-	if err != nil {
-		return false, fmt.Errorf("failed to parse workflow files in %s: %w", fa.ActionsPath, err)
-	}
+	var modified bool
+	for _, path := range paths {
+		log.Printf("Parsing workflow files in %s...", path)
+		res, err := fa.parseWithTimeout(ctx, path, isYAMLOrDockerfile, fa.ActionsReplacer.ParsePath)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse workflow files in %s: %w", path, err)
+		}
+
+		// Pin the images of the job containers and services too, matching them by their place in the workflow
+		imagesRes, err := fa.parseWithTimeout(ctx, path, workflowWalker.match,
+			func(ctx context.Context, path string) (*replacer.ReplaceResult, error) {
+				return fa.parseWithWalker(ctx, path, workflowWalker)
+			})
+		if err != nil {
+			return false, fmt.Errorf("failed to parse container images in %s: %w", path, err)
+		}
 
-	return fa.processOutput(res, fa.ActionsPath)
+		// Only keep the changes made to `uses:` keys, so references mentioned in `run:` scripts are left alone, and
+		// merge them with the pinned images and nested action inputs
+		bfs := osfs.New(filepath.Dir(path), osfs.WithBoundOS())
+		merged := make(map[string]string)
+		imageLines := make(map[string][]int)
+		for _, file := range res.Processed {
+			actionsContent, actionsModified := res.Modified[file]
+			imagesContent, imagesModified := imagesRes.Modified[file]
+			if !actionsModified && !imagesModified && len(fa.NestedActionKeys) == 0 {
+				continue
+			}
+			original, err := readFile(bfs, file)
+			if err != nil {
+				return false, err
+			}
+			content := original
+			if actionsModified {
+				actionsContent = preserveLineEndings(original, withTrailingNewline(original, actionsContent))
+				content = keepKeyChanges(original, actionsContent, usesKeyRegex)
+			}
+			if imagesModified {
+				content = mergeChanges(original, content, imagesContent)
+				imageLines[file] = changedLines(original, imagesContent)
+			}
+			if len(fa.NestedActionKeys) > 0 {
+				content = mergeChanges(original, content, fa.pinNestedActionInputs(ctx, original))
+			}
+			if content != original {
+				merged[file] = content
+			}
+		}
+		res.Modified = merged
+
+		m, err := fa.processOutput(ctx, res, path, actions.ReferenceType, imageLines)
+		if err != nil {
+			return false, fmt.Errorf("failed to process output: %w", err)
+		}
+		modified = modified || m
+	}
+	return modified, nil
 }
 
-// parseImages parses the Dockerfiles, Docker Compose, and Kubernetes files for container images.
+// parseImages parses the Dockerfiles, Docker Compose, Kubernetes, dev container and Quadlet files for container images.
 // It also updates the files if the OpenPR flag is set
-func (fa *FrizbeeAction) parseImages(ctx context.Context) (bool, error) {
+func (fa *FrizbeeAction) parseImages(ctx context.Context, paths []string, walkers []walkerPath) (bool, error) {
 	var modified bool
-	pathsToParse := []string{fa.DockerfilesPath, fa.DockerComposePath, fa.KubernetesPath}
-	for _, path := range pathsToParse {
-		if path == "" {
-			continue
-		}
+	for _, path := range paths {
 		log.Printf("Parsing files for container images in %s", path)
-		res, err := fa.ImagesReplacer.ParsePath(ctx, path)
+		res, err := fa.parseWithTimeout(ctx, path, isYAMLOrDockerfile, fa.parseImagePath)
 		if err != nil {
 			return false, fmt.Errorf("failed to parse: %w", err)
 		}
 		// Process the parsing output
-		m, err := fa.processOutput(res, path)
+		m, err := fa.processOutput(ctx, res, path, image.ReferenceType, nil)
 		if err != nil {
 			return false, fmt.Errorf("failed to process output: %w", err)
 		}
 		// Set the modified flag to true if any file was modified
 		modified = modified || m
 	}
+
+	// Parse the files in formats the images replacer can't handle using their own walkers
+	for _, w := range walkers {
+		log.Printf("Parsing %s files for container images in %s", w.walker.name, w.path)
+		res, err := fa.parseWithTimeout(ctx, w.path, w.walker.match,
+			func(ctx context.Context, path string) (*replacer.ReplaceResult, error) {
+				return fa.parseWithWalker(ctx, path, w.walker)
+			})
+		if err != nil {
+			return false, fmt.Errorf("failed to parse: %w", err)
+		}
+		m, err := fa.processOutput(ctx, res, w.path, image.ReferenceType, nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to process output: %w", err)
+		}
+		modified = modified || m
+	}
 	return modified, nil
 }
 
+// parseImagePath parses the files in the path for container images, resolving them from the local daemon first if
+// it is available. Compose images using variables are pinned too if an env file is set
+func (fa *FrizbeeAction) parseImagePath(ctx context.Context, path string) (*replacer.ReplaceResult, error) {
+	var res *replacer.ReplaceResult
+	var err error
+	if fa.daemon != nil {
+		res, err = fa.daemon.parsePath(ctx, fa.ImagesReplacer, path)
+	} else {
+		res, err = fa.ImagesReplacer.ParsePath(ctx, path)
+	}
+	if err != nil || fa.env == nil {
+		return res, err
+	}
+	// Pin the Compose images using the variables of the env file, which the replacer skips
+	return res, fa.pinInterpolatedImages(ctx, res, path)
+}
+
 // processOutput processes the output of a replacer, prints the processed and modified files and writes the
-// changes to the files
-func (fa *FrizbeeAction) processOutput(res *replacer.ReplaceResult, baseDir string) (bool, error) {
+// changes to the files. The findings are of the given type, except the ones on the 1-based lines of imageLines by
+// path, which were pinned as container images
+func (fa *FrizbeeAction) processOutput(
+	ctx context.Context, res *replacer.ReplaceResult, baseDir, refType string, imageLines map[string][]int,
+) (bool, error) {
 	var modified bool
-	bfs := osfs.New(baseDir, osfs.WithBoundOS())
+	// The paths returned by the replacer are relative to the parent of the parsed directory
+	parentDir := filepath.Dir(baseDir)
+	bfs := osfs.New(parentDir, osfs.WithBoundOS())
 
-	// Show the processed files
+	// Show the processed files, once per file even if symlinks point to it
+	if fa.processedSeen == nil {
+		fa.processedSeen = make(map[string]bool)
+		fa.modifiedSeen = make(map[string]bool)
+	}
 	for _, path := range res.Processed {
-		log.Printf("Processed file: %s", filepath.Base(path))
+		canonical, follow, err := fa.canonicalPath(filepath.Join(parentDir, path))
+		if err != nil {
+			return modified, err
+		}
+		if !follow || !visit(fa.processedSeen, canonical) {
+			continue
+		}
+		log.Printf("Processed file: %s", path)
+		fa.processedFiles = append(fa.processedFiles, canonical)
+		if err := fa.recordPinned(canonical); err != nil {
+			return modified, err
+		}
+	}
+
+	// Advance the pins whose tag moved, including in the files without unpinned references
+	refreshedLines := make(map[string][]int)
+	if fa.RefreshPins {
+		for _, path := range res.Processed {
+			content, ok := res.Modified[path]
+			if !ok {
+				original, err := readFile(bfs, path)
+				if err != nil {
+					return modified, err
+				}
+				content = original
+			}
+			if refreshed, lines := fa.refreshPins(ctx, path, content); len(lines) > 0 {
+				res.Modified[path] = refreshed
+				refreshedLines[path] = lines
+			}
+		}
 	}
 
 	// Process the modified files
 	for path, content := range res.Modified {
-		log.Printf("Modified file: %s", filepath.Base(path))
-		log.Printf("Modified content:\n%s\n", content)
-		// Overwrite the content of the file with the changes if the OpenPR flag is set
-		if fa.OpenPR {
-			f, err := bfs.OpenFile(filepath.Base(path), os.O_WRONLY|os.O_TRUNC, 0644)
+		refreshed, images := refreshedLines[path], imageLines[path]
+		// Pin the target of symlinks rather than the symlinks themselves, and only once
+		repoPath, follow, err := fa.canonicalPath(filepath.Join(parentDir, path))
+		if err != nil {
+			return modified, err
+		}
+		if !follow || !visit(fa.modifiedSeen, repoPath) {
+			continue
+		}
+		// The target of a symlink may be outside of the parsed directory, but never outside of the repository
+		if filepath.IsAbs(repoPath) || strings.HasPrefix(repoPath, "..") {
+			log.Printf("Skipping %s: it is a symlink to %s, outside of the repository", path, repoPath)
+			continue
+		}
+		fsys := bfs
+		if target, err := filepath.Rel(parentDir, repoPath); err == nil && !strings.HasPrefix(target, "..") {
+			path = target
+		} else {
+			fsys, path = osfs.New(".", osfs.WithBoundOS()), repoPath
+		}
+		log.Printf("Modified file: %s", path)
+		original, err := readFile(fsys, path)
+		if err != nil {
+			return modified, err
+		}
+		content = preserveLineEndings(original, withTrailingNewline(original, content))
+		// Never pin to the digest of a signature, attestation or other referrer artifact
+		content, err = fa.withoutArtifacts(ctx, path, original, content)
+		if err != nil {
+			return modified, err
+		}
+		// Only pin the images signed by the expected identity, if set
+		content = fa.withVerifiedSignatures(ctx, path, repoPath, original, content)
+		if content == original {
+			continue
+		}
+		// Make sure the pinned digests are still reachable right before writing them
+		if fa.ConfirmDigests {
+			content, err = fa.confirmDigests(ctx, path, original, content)
 			if err != nil {
-				return modified, fmt.Errorf("failed to open file %s: %w", filepath.Base(path), err)
+				return modified, err
+			}
+			if content == original {
+				continue
+			}
+		}
+		content, err = fa.withDigestAlgorithm(ctx, path, original, content)
+		if err != nil {
+			return modified, err
+		}
+		content = fa.withoutTags(original, content)
+		for _, f := range findingsFromContent(repoPath, refType, original, content) {
+			if slices.Contains(images, f.Line) {
+				f.Type = image.ReferenceType
+			}
+			if slices.Contains(refreshed, f.Line) {
+				fa.refreshed = append(fa.refreshed, f)
+			} else {
+				fa.findings = append(fa.findings, f)
+			}
+		}
+		if err := fa.haltOnUnpinned(); err != nil {
+			return modified, err
+		}
+		// Record the tags of the pins as configured, once the findings are known as it may add lines
+		content = fa.withCommentStyle(original, content)
+		log.Printf("Changes:\n%s\n", unifiedDiff(path, original, content, fa.DiffContext))
+		fa.changes = append(fa.changes, fileChange{Path: repoPath, Original: original, Modified: content})
+		// Write the changed files to the output directory instead of in place, if set
+		if fa.TransformOnly && fa.OutputDir != "" {
+			if err := writeOutputFile(fa.OutputDir, repoPath, content); err != nil {
+				return modified, err
+			}
+			modified = true
+			fa.modifiedFiles = append(fa.modifiedFiles, repoPath)
+			continue
+		}
+		// Overwrite the content of the file with the changes if the OpenPR or TransformOnly flag is set
+		if fa.OpenPR || fa.TransformOnly {
+			f, err := fsys.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return modified, fmt.Errorf("failed to open file %s: %w", path, err)
 			}
 			defer func() {
 				if err := f.Close(); err != nil {
-					log.Fatalf("failed to close file %s: %v", filepath.Base(path), err) // nolint:errcheck
+					log.Fatalf("failed to close file %s: %v", path, err) // nolint:errcheck
 				}
 			}()
 			_, err = fmt.Fprintf(f, "%s", content)
 			if err != nil {
-				return modified, fmt.Errorf("failed to write to file %s: %w", filepath.Base(path), err)
+				return modified, fmt.Errorf("failed to write to file %s: %w", path, err)
 			}
 			// Set the modified flag to true if any file was modified
 			modified = true
+			fa.modifiedFiles = append(fa.modifiedFiles, repoPath)
 		}
 	}
 	return modified, nil
 }
+
+// headOwner returns the owner of the fork the branch is pushed to, or an empty string if the branch is pushed to the
+// repository itself
+func (fa *FrizbeeAction) headOwner() (string, error) {
+	if fa.GitRemote == pull_request.DefaultRemote {
+		return "", nil
+	}
+	owner, err := pull_request.RemoteOwner(fa.GitRemote)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the owner of remote %s: %w", fa.GitRemote, err)
+	}
+	if strings.EqualFold(owner, fa.RepoOwner) {
+		return "", nil
+	}
+	return owner, nil
+}
+
+// checkBranchUnprotected makes sure the current branch can be committed to directly, i.e. it is not protected
+func (fa *FrizbeeAction) checkBranchUnprotected(ctx context.Context) error {
+	if fa.CurrentBranch == "" {
+		return fmt.Errorf("committing to the current branch requires a branch to be checked out, not a tag or a " +
+			"pull request merge commit")
+	}
+	branch, _, err := fa.Client.Repositories.GetBranch(ctx, fa.RepoOwner, fa.RepoName, fa.CurrentBranch, 1)
+	if err != nil {
+		return fmt.Errorf("failed to get branch %s: %w", fa.CurrentBranch, err)
+	}
+	if branch.GetProtected() {
+		return fmt.Errorf("branch %s is protected, so the changes can't be committed to it directly. Disable "+
+			"commit_to_current_branch to open a pull request instead", fa.CurrentBranch)
+	}
+	return nil
+}
+
+// isDryRun returns true if the action only reports the findings without applying any changes
+func (fa *FrizbeeAction) isDryRun() bool {
+	return !fa.OpenPR && !fa.TransformOnly
+}
+
+// hookEnv returns the extra environment variables passed to the hooks
+func (fa *FrizbeeAction) hookEnv() []string {
+	return []string{"FRIZBEE_MODIFIED_FILES=" + strings.Join(fa.modifiedFiles, "\n")}
+}
+
+// withTrailingNewline makes the modified content end with a newline only if the original content does, as the
+// replacers write a newline after every line, so both have the same number of lines
+func withTrailingNewline(original, modified string) string {
+	if strings.HasSuffix(original, "\n") || original == "" {
+		return modified
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(modified, "\n"), "\r")
+}
+
+// preserveLineEndings restores the line endings of the original content in the modified content, as the replacers
+// normalize them to LF. This keeps the diff of files using CRLF limited to the pinned lines
+func preserveLineEndings(original, modified string) string {
+	if !strings.Contains(original, "\r\n") {
+		return modified
+	}
+	originalLines := strings.Split(original, "\n")
+	modifiedLines := strings.Split(strings.ReplaceAll(modified, "\r\n", "\n"), "\n")
+	// Use the ending of the same line in the original content, or the predominant ending if the lines don't match
+	crlf := strings.Count(original, "\r\n")*2 > strings.Count(original, "\n")
+	for i := 0; i < len(modifiedLines)-1; i++ {
+		hasCR := crlf
+		if len(originalLines) == len(modifiedLines) {
+			hasCR = strings.HasSuffix(originalLines[i], "\r")
+		}
+		if hasCR {
+			modifiedLines[i] += "\r"
+		}
+	}
+	return strings.Join(modifiedLines, "\n")
+}
+
+// keepKeyChanges reverts every line of the modified content that does not declare the given key back to its
+// original value. This makes sure that only the references of the key are pinned, leaving `run:` steps untouched.
+// The original content is returned if the lines of both don't match, as the changes can't be told apart
+func keepKeyChanges(original, modified string, keyRegex *regexp.Regexp) string {
+	originalLines := strings.Split(original, "\n")
+	modifiedLines := strings.Split(modified, "\n")
+	if len(originalLines) != len(modifiedLines) {
+		log.Printf("Warning: not pinning the file, the lines of the pinned content don't match the original ones")
+		return original
+	}
+	for i := range modifiedLines {
+		if modifiedLines[i] != originalLines[i] && !keyRegex.MatchString(originalLines[i]) {
+			modifiedLines[i] = originalLines[i]
+		}
+	}
+	return strings.Join(modifiedLines, "\n")
+}
+
+// mergeChanges merges the line changes of several modified versions of the original content. The versions whose
+// number of lines differs from the original are skipped, as their changes can't be told apart
+func mergeChanges(original string, modified ...string) string {
+	originalLines := strings.Split(original, "\n")
+	mergedLines := strings.Split(original, "\n")
+	for _, m := range modified {
+		modifiedLines := strings.Split(m, "\n")
+		if len(modifiedLines) != len(originalLines) {
+			log.Printf("Warning: skipping changes whose lines don't match the original ones")
+			continue
+		}
+		for i := range modifiedLines {
+			if modifiedLines[i] != originalLines[i] {
+				mergedLines[i] = modifiedLines[i]
+			}
+		}
+	}
+	return strings.Join(mergedLines, "\n")
+}
+
+// changedLines returns the 1-based numbers of the lines changed in the modified content, which must have as many lines
+// as the original one
+func changedLines(original, modified string) []int {
+	originalLines := strings.Split(original, "\n")
+	modifiedLines := strings.Split(modified, "\n")
+	if len(modifiedLines) != len(originalLines) {
+		return nil
+	}
+	var lines []int
+	for i := range modifiedLines {
+		if modifiedLines[i] != originalLines[i] {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// haltOnUnpinned returns ErrUnpinnedFound as soon as an unpinned reference not accepted in the baseline is found, if
+// the HaltOnFirstUnpinned flag is set, logging that reference only
+func (fa *FrizbeeAction) haltOnUnpinned() error {
+	if !fa.HaltOnFirstUnpinned || len(fa.findings) == 0 {
+		return nil
+	}
+	var accepted map[string]bool
+	if fa.BaselineFile != "" {
+		var err error
+		if accepted, err = fa.readBaseline(); err != nil {
+			return err
+		}
+	}
+	for _, f := range fa.findings {
+		if accepted[baselineKey(f)] {
+			continue
+		}
+		log.Printf("Halting on the first unpinned %s reference, in %s:%d: %s", f.Type, filepath.ToSlash(f.File),
+			f.Line, findingRef(f.Original))
+		return ErrUnpinnedFound
+	}
+	return nil
+}
+
+// writeOutputFile writes the content of the file to the same path relative to the output directory, creating the
+// parent directories if needed
+func writeOutputFile(outputDir, path, content string) error {
+	outputPath := filepath.Join(outputDir, path)
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0750); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", outputPath, err)
+	}
+	if err := os.WriteFile(outputPath, []byte(content), 0644); err != nil { // nolint:gosec
+		return fmt.Errorf("failed to write file %s: %w", outputPath, err)
+	}
+	return nil
+}
+
+// readFile reads the content of the file at the given path in the filesystem
+func readFile(bfs billy.Filesystem, path string) (string, error) {
+	f, err := bfs.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer f.Close() // nolint:errcheck
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	return string(content), nil
+}
@@ -0,0 +1,95 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// refPrefixRegex matches the part of a line preceding the reference, i.e. a YAML, JSON or INI key or a FROM instruction
+var refPrefixRegex = regexp.MustCompile(`^(-\s+)?("?[\w.-]+"?\s*[:=]|(?i:FROM)(\s+--\S+)*)\s*`)
+
+// baselineKey returns the key identifying the finding in a baseline, in the form `file:line:ref`
+func baselineKey(f Finding) string {
+	return fmt.Sprintf("%s:%d:%s", filepath.ToSlash(f.File), f.Line, findingRef(f.Original))
+}
+
+// findingRef returns the unpinned reference of the original line of a finding
+func findingRef(line string) string {
+	fields := strings.Fields(refPrefixRegex.ReplaceAllString(line, ""))
+	if len(fields) == 0 {
+		return line
+	}
+	return strings.Trim(fields[0], `"',`)
+}
+
+// writeBaseline writes the keys of all the findings to the baseline file, accepting them in the following runs
+func (fa *FrizbeeAction) writeBaseline() error {
+	keys := make([]string, 0, len(fa.findings))
+	for _, f := range fa.findings {
+		keys = append(keys, baselineKey(f))
+	}
+	content, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal baseline: %w", err)
+	}
+	if err := os.WriteFile(fa.BaselineFile, append(content, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write baseline %s: %w", fa.BaselineFile, err)
+	}
+	log.Printf("Wrote %d accepted findings to the baseline %s", len(keys), fa.BaselineFile)
+	return nil
+}
+
+// readBaseline returns the keys of the findings accepted in the baseline file
+func (fa *FrizbeeAction) readBaseline() (map[string]bool, error) {
+	content, err := os.ReadFile(fa.BaselineFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline %s: %w", fa.BaselineFile, err)
+	}
+	var keys []string
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline %s: %w", fa.BaselineFile, err)
+	}
+	accepted := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		accepted[k] = true
+	}
+	return accepted, nil
+}
+
+// applyBaseline removes the findings accepted in the baseline file from the findings of the run
+func (fa *FrizbeeAction) applyBaseline() error {
+	accepted, err := fa.readBaseline()
+	if err != nil {
+		return err
+	}
+
+	var findings []Finding
+	for _, f := range fa.findings {
+		if !accepted[baselineKey(f)] {
+			findings = append(findings, f)
+		}
+	}
+	log.Printf("Ignoring %d findings accepted in the baseline %s", len(fa.findings)-len(findings), fa.BaselineFile)
+	fa.findings = findings
+	return nil
+}
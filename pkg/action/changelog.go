@@ -0,0 +1,53 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+// DefaultChangelogFragment is the default template of the changelog fragment, listing the pinned references
+const DefaultChangelogFragment = "Pin {{.PinCount}} dependencies to their commit hash or digest:\n" +
+	"{{range .Pins}}\n- `{{.Original}}` to `{{.Pinned}}`{{end}}\n"
+
+// withChangelogFragment returns the commits preceded by the commit adding the changelog fragment describing the pins,
+// if requested, for the projects requiring a news fragment in every pull request. It's committed first so the last
+// commit still includes all the remaining changes
+func (fa *FrizbeeAction) withChangelogFragment(pinned []Finding, commits []pull_request.Commit) (
+	[]pull_request.Commit, error) {
+	if fa.ChangelogFragment == nil {
+		return commits, nil
+	}
+	data := fa.templateData(pinned)
+	var path, content strings.Builder
+	if err := fa.ChangelogFragment.Execute(&path, data); err != nil {
+		return nil, fmt.Errorf("failed to execute the changelog fragment template: %w", err)
+	}
+	if err := fa.ChangelogFragmentBody.Execute(&content, data); err != nil {
+		return nil, fmt.Errorf("failed to execute the changelog fragment body template: %w", err)
+	}
+	file := filepath.Clean(strings.TrimSpace(path.String()))
+	if !filepath.IsLocal(file) {
+		return nil, fmt.Errorf("changelog fragment %q is outside the repository", file)
+	}
+	message := fa.withCommitType(pull_request.DefaultChangelogCommitMessage)
+	fragment := pull_request.Commit{Message: message, Files: map[string]string{file: content.String()}}
+	return append([]pull_request.Commit{fragment}, commits...), nil
+}
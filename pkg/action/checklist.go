@@ -0,0 +1,177 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+)
+
+// checklistCollapseThreshold is the number of checklist items above which the checklist is collapsed
+const checklistCollapseThreshold = 10
+
+// withChecklist appends a reviewer checklist of the pinned references to the given pull request body if the
+// PRChecklist flag is set
+func (fa *FrizbeeAction) withChecklist(body string, findings []Finding) string {
+	// Only the references pinned by the PR need to be verified
+	var pinned []Finding
+	for _, f := range findings {
+		if f.Pinned != "" {
+			pinned = append(pinned, f)
+		}
+	}
+	if !fa.PRChecklist || len(pinned) == 0 {
+		return body
+	}
+	return body + "\n\n" + checklist(pinned)
+}
+
+// checklist returns a Markdown checklist with an item per pinned reference, mapping the original reference to the
+// pinned one along with a link to its source. Long checklists are collapsed
+func checklist(findings []Finding) string {
+	var b strings.Builder
+	for _, f := range findings {
+		fmt.Fprintf(&b, "- [ ] `%s:%d`: `%s` → `%s`", filepath.ToSlash(f.File), f.Line, findingRef(f.Original),
+			findingRef(f.Pinned))
+		if link := sourceLink(f); link != "" {
+			fmt.Fprintf(&b, " ([source](%s))", link)
+		}
+		b.WriteString("\n")
+	}
+	items := strings.TrimSuffix(b.String(), "\n")
+
+	if len(findings) <= checklistCollapseThreshold {
+		return "### Reviewer checklist\n\n" + items
+	}
+	return fmt.Sprintf("<details>\n<summary>Reviewer checklist (%d references)</summary>\n\n%s\n\n</details>",
+		len(findings), items)
+}
+
+// sourceLink returns a link to the source of the pinned reference of the finding, i.e. the commit of an action or
+// the repository of an image, or an empty string if it is unknown
+func sourceLink(f Finding) string {
+	ref := findingRef(f.Pinned)
+	if f.Type == actions.ReferenceType {
+		action, sha, ok := strings.Cut(ref, "@")
+		parts := strings.Split(action, "/")
+		if !ok || len(parts) < 2 {
+			return ""
+		}
+		return fmt.Sprintf("https://github.com/%s/%s/commit/%s", parts[0], parts[1], sha)
+	}
+
+	r, err := name.ParseReference(strings.TrimPrefix(ref, "docker://"))
+	if err != nil {
+		return ""
+	}
+	repo := r.Context().RepositoryStr()
+	switch r.Context().RegistryStr() {
+	case name.DefaultRegistry:
+		if library, ok := strings.CutPrefix(repo, "library/"); ok {
+			return "https://hub.docker.com/_/" + library
+		}
+		return "https://hub.docker.com/r/" + repo
+	case "ghcr.io":
+		return "https://ghcr.io/" + repo
+	case "quay.io":
+		return "https://quay.io/repository/" + repo
+	default:
+		return ""
+	}
+}
+
+// pinTable returns a Markdown table with a row per pinned dependency, listing the reference it was pinned from, the
+// SHA or digest it was pinned to, a link to its upstream tag or image and to the release notes of the tag, if any
+func pinTable(groups []FindingGroup, releases map[string]string) string {
+	if len(groups) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("| Dependency | From | To | Upstream | Release notes |\n" +
+		"|------------|------|----|----------|---------------|\n")
+	for _, g := range groups {
+		dependency, from := splitReference(g.Type, g.Original)
+		_, to := splitReference(g.Type, g.Pinned)
+		link := upstreamLink(g.Type, g.Original, g.Pinned)
+		if link != "" {
+			link = fmt.Sprintf("[%s](%s)", from, link)
+		}
+		notes := releases[g.Original]
+		if notes != "" {
+			notes = fmt.Sprintf("[%s](%s)", from, notes)
+		}
+		fmt.Fprintf(&b, "| `%s` | `%s` | `%s` | %s | %s |\n", dependency, from, to, link, notes)
+	}
+	return b.String()
+}
+
+// releaseNotes returns the links to the GitHub releases of the tags the actions were pinned from, by original
+// reference. Tags without a release, i.e. the major version tags of most actions, and branches have no link
+func (fa *FrizbeeAction) releaseNotes(ctx context.Context, groups []FindingGroup) map[string]string {
+	releases := make(map[string]string)
+	for _, g := range groups {
+		if g.Type != actions.ReferenceType {
+			continue
+		}
+		action, tag, ok := strings.Cut(g.Original, "@")
+		parts := strings.Split(action, "/")
+		if !ok || len(parts) < 2 {
+			continue
+		}
+		release, _, err := fa.Client.Repositories.GetReleaseByTag(ctx, parts[0], parts[1], tag)
+		if err != nil {
+			continue
+		}
+		releases[g.Original] = release.GetHTMLURL()
+	}
+	return releases
+}
+
+// splitReference splits the reference into the name of the dependency and its version, i.e. the tag or branch of an
+// action or the tag or digest of an image
+func splitReference(refType, ref string) (string, string) {
+	if refType == actions.ReferenceType {
+		dependency, version, _ := strings.Cut(ref, "@")
+		return dependency, version
+	}
+	if dependency, digest, ok := strings.Cut(ref, "@"); ok {
+		return dependency, digest
+	}
+	// The tag follows the last colon, unless it is the port of the registry
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// upstreamLink returns a link to the tag of the action or to the image the dependency was pinned from, or an empty
+// string if it is unknown
+func upstreamLink(refType, original, pinned string) string {
+	if refType != actions.ReferenceType {
+		return sourceLink(Finding{Type: refType, Pinned: pinned})
+	}
+	action, tag, ok := strings.Cut(original, "@")
+	parts := strings.Split(action, "/")
+	if !ok || len(parts) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("https://github.com/%s/%s/tree/%s", parts[0], parts[1], tag)
+}
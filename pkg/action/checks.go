@@ -0,0 +1,137 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+)
+
+const (
+	// checkRunName is the name of the check run published by the action
+	checkRunName = "frizbee"
+	// maxAnnotationsPerRequest is the maximum number of annotations the checks API accepts in a single request
+	maxAnnotationsPerRequest = 50
+)
+
+// publishCheckRun publishes a completed check run summarizing the findings, with an annotation for each of them
+func (fa *FrizbeeAction) publishCheckRun(ctx context.Context) error {
+	if fa.HeadSHA == "" {
+		return fmt.Errorf("cannot publish a check run without the commit SHA")
+	}
+
+	conclusion := fa.checkRunConclusion()
+	title := "All actions and container images are pinned"
+	if len(fa.findings) > 0 {
+		title = fmt.Sprintf("Found %d unpinned references", len(fa.findings))
+	}
+
+	// Summarize the number of findings per file
+	var summary strings.Builder
+	counts := make(map[string]int)
+	var files []string
+	for _, f := range fa.findings {
+		if counts[f.File] == 0 {
+			files = append(files, f.File)
+		}
+		counts[f.File]++
+	}
+	for _, file := range files {
+		fmt.Fprintf(&summary, "- `%s`: %d unpinned references\n", file, counts[file])
+	}
+	if summary.Len() == 0 {
+		summary.WriteString("No unpinned actions or container images were found.")
+	}
+
+	annotations := fa.checkRunAnnotations(conclusion)
+	first := annotations[:min(len(annotations), maxAnnotationsPerRequest)]
+	checkRun, _, err := fa.Client.Checks.CreateCheckRun(ctx, fa.RepoOwner, fa.RepoName, github.CreateCheckRunOptions{
+		Name:        checkRunName,
+		HeadSHA:     fa.HeadSHA,
+		Status:      github.String("completed"),
+		Conclusion:  github.String(conclusion),
+		CompletedAt: &github.Timestamp{Time: time.Now()},
+		Output: &github.CheckRunOutput{
+			Title:       github.String(title),
+			Summary:     github.String(summary.String()),
+			Annotations: first,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create check run: %w", err)
+	}
+
+	// The checks API limits the number of annotations per request, so add the rest in batches
+	for i := len(first); i < len(annotations); i += maxAnnotationsPerRequest {
+		batch := annotations[i:min(len(annotations), i+maxAnnotationsPerRequest)]
+		_, _, err := fa.Client.Checks.UpdateCheckRun(ctx, fa.RepoOwner, fa.RepoName, checkRun.GetID(),
+			github.UpdateCheckRunOptions{
+				Name: checkRunName,
+				Output: &github.CheckRunOutput{
+					Title:       github.String(title),
+					Summary:     github.String(summary.String()),
+					Annotations: batch,
+				},
+			})
+		if err != nil {
+			return fmt.Errorf("failed to add annotations to check run: %w", err)
+		}
+	}
+
+	log.Printf("Published check run %s with conclusion %s", checkRun.GetHTMLURL(), conclusion)
+	return nil
+}
+
+// checkRunConclusion returns the conclusion of the check run based on the findings and the FailOnUnpinned flag
+func (fa *FrizbeeAction) checkRunConclusion() string {
+	switch {
+	case len(fa.findings) == 0:
+		return "success"
+	case fa.FailOnUnpinned:
+		return "failure"
+	default:
+		return "neutral"
+	}
+}
+
+// checkRunAnnotations returns an annotation for each of the findings
+func (fa *FrizbeeAction) checkRunAnnotations(conclusion string) []*github.CheckRunAnnotation {
+	level := "warning"
+	if conclusion == "failure" {
+		level = "failure"
+	}
+	annotations := make([]*github.CheckRunAnnotation, 0, len(fa.findings))
+	for _, f := range fa.findings {
+		message := fmt.Sprintf("%s\nshould be pinned as\n%s", f.Original, f.Pinned)
+		if f.Pinned == "" {
+			message = fmt.Sprintf("%s\n%s", f.Original, f.Reason)
+		}
+		annotations = append(annotations, &github.CheckRunAnnotation{
+			Path:            github.String(f.File),
+			StartLine:       github.Int(f.Line),
+			EndLine:         github.Int(f.Line),
+			AnnotationLevel: github.String(level),
+			Title:           github.String(fmt.Sprintf("Unpinned %s reference", f.Type)),
+			Message:         github.String(message),
+		})
+	}
+	return annotations
+}
@@ -0,0 +1,359 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// workflowWalker pins the `container` of the jobs of the GitHub Actions workflows, whether set to the image or to a
+// mapping with its `image`, and the `image` of their `services`
+var workflowWalker = lineWalker{
+	name: "workflow",
+	match: func(fileName string) bool {
+		return strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml")
+	},
+	images: workflowImages,
+}
+
+// workflowImages returns the images of the job containers and services of a GitHub Actions workflow, leaving out
+// the `image` keys found anywhere else, i.e. in the inputs of the steps
+func workflowImages(doc *yaml.Node) []*yaml.Node {
+	jobs := mappingValue(doc, "jobs")
+	if jobs == nil || jobs.Kind != yaml.MappingNode {
+		return nil
+	}
+	var nodes []*yaml.Node
+	for i := 1; i < len(jobs.Content); i += 2 {
+		nodes = append(nodes, imageNodes(mappingValue(jobs.Content[i], "container"), "image")...)
+		services := mappingValue(jobs.Content[i], "services")
+		if services == nil || services.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 1; j < len(services.Content); j += 2 {
+			if services.Content[j].Kind == yaml.MappingNode {
+				nodes = append(nodes, imageNodes(services.Content[j], "image")...)
+			}
+		}
+	}
+	return nodes
+}
+
+// gitlabCIWalker pins the `image` and `services` of the GitLab CI configurations, globally, in `default` and in the
+// jobs, whether they are set to the image or to a mapping with its `name`
+var gitlabCIWalker = lineWalker{
+	name: "GitLab CI",
+	match: func(fileName string) bool {
+		return strings.HasSuffix(fileName, ".gitlab-ci.yml") || strings.HasSuffix(fileName, ".gitlab-ci.yaml")
+	},
+	images: gitlabCIImages,
+}
+
+// gitlabCIImages returns the image references of a GitLab CI configuration, skipping the variables, which may be
+// named image too
+func gitlabCIImages(doc *yaml.Node) []*yaml.Node {
+	if doc.Kind != yaml.MappingNode {
+		return nil
+	}
+	nodes := jobImages(doc)
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "variables" && doc.Content[i+1].Kind == yaml.MappingNode {
+			nodes = append(nodes, jobImages(doc.Content[i+1])...)
+		}
+	}
+	return nodes
+}
+
+// jobImages returns the `image` and the `services` of a GitLab CI job or of its defaults
+func jobImages(job *yaml.Node) []*yaml.Node {
+	nodes := imageNodes(mappingValue(job, "image"), "name")
+	if services := mappingValue(job, "services"); services != nil && services.Kind == yaml.SequenceNode {
+		for _, service := range services.Content {
+			nodes = append(nodes, imageNodes(service, "name")...)
+		}
+	}
+	return nodes
+}
+
+// azurePipelinesWalker pins the images of the container resources of the Azure Pipelines and the `container` of
+// their jobs, whether set to the image or to a mapping with its `image`
+var azurePipelinesWalker = lineWalker{
+	name: "Azure Pipelines",
+	match: func(fileName string) bool {
+		return strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml")
+	},
+	images: azurePipelinesImages,
+}
+
+// isAzurePipelines returns true if the path is an Azure Pipelines definition, i.e. azure-pipelines.yml or a file of
+// the .azure-pipelines directory
+func isAzurePipelines(path string) bool {
+	fileName := filepath.Base(path)
+	return (strings.HasPrefix(fileName, "azure-pipelines") || strings.Contains(filepath.ToSlash(path),
+		".azure-pipelines/")) && (strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml"))
+}
+
+// azurePipelinesImages returns the images of the container resources of an Azure Pipelines definition and the
+// container of its jobs, whether in stages, in jobs or of the single job of the pipeline. The containers of the jobs
+// naming a container resource are skipped, the resource being pinned instead
+func azurePipelinesImages(doc *yaml.Node) []*yaml.Node {
+	var nodes []*yaml.Node
+	aliases := make(map[string]bool)
+	if containers := mappingValue(mappingValue(doc, "resources"), "containers"); containers != nil &&
+		containers.Kind == yaml.SequenceNode {
+		for _, c := range containers.Content {
+			if alias := mappingValue(c, "container"); alias != nil {
+				aliases[alias.Value] = true
+			}
+			if c.Kind == yaml.MappingNode {
+				nodes = append(nodes, imageNodes(c, "image")...)
+			}
+		}
+	}
+
+	jobs := []*yaml.Node{doc}
+	stages := []*yaml.Node{doc}
+	if s := mappingValue(doc, "stages"); s != nil && s.Kind == yaml.SequenceNode {
+		stages = append(stages, s.Content...)
+	}
+	for _, stage := range stages {
+		if j := mappingValue(stage, "jobs"); j != nil && j.Kind == yaml.SequenceNode {
+			jobs = append(jobs, j.Content...)
+		}
+	}
+	for _, job := range jobs {
+		for _, n := range imageNodes(mappingValue(job, "container"), "image") {
+			if !aliases[n.Value] {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	return nodes
+}
+
+// bitbucketPipelinesWalker pins the default `image` of the Bitbucket Pipelines, the ones of their steps and the ones
+// of the service definitions, whether set to the image or to a mapping with its `name`
+var bitbucketPipelinesWalker = lineWalker{
+	name: "Bitbucket Pipelines",
+	match: func(fileName string) bool {
+		return fileName == "bitbucket-pipelines.yml" || fileName == "bitbucket-pipelines.yaml"
+	},
+	images: bitbucketPipelinesImages,
+}
+
+// bitbucketPipelinesImages returns the images of a Bitbucket Pipelines configuration: the default one, the ones of
+// the services and the ones of the steps, wherever they are nested, i.e. in parallel groups or stages
+func bitbucketPipelinesImages(doc *yaml.Node) []*yaml.Node {
+	nodes := imageNodes(mappingValue(doc, "image"), "name")
+	if services := mappingValue(mappingValue(doc, "definitions"), "services"); services != nil &&
+		services.Kind == yaml.MappingNode {
+		for i := 1; i < len(services.Content); i += 2 {
+			nodes = append(nodes, imageNodes(mappingValue(services.Content[i], "image"), "name")...)
+		}
+	}
+	return append(nodes, stepImages(doc)...)
+}
+
+// stepImages returns the images of the Bitbucket Pipelines steps in the node and its descendants. Steps reused with
+// YAML aliases are only returned where they are defined
+func stepImages(node *yaml.Node) []*yaml.Node {
+	var nodes []*yaml.Node
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "step" {
+				nodes = append(nodes, imageNodes(mappingValue(node.Content[i+1], "image"), "name")...)
+			}
+			nodes = append(nodes, stepImages(node.Content[i+1])...)
+		}
+	case yaml.SequenceNode:
+		for _, n := range node.Content {
+			nodes = append(nodes, stepImages(n)...)
+		}
+	}
+	return nodes
+}
+
+// tektonWalker pins the images of the steps, the step template and the sidecars of the Tekton tasks, including the
+// tasks embedded in the pipelines and in the runs
+var tektonWalker = lineWalker{
+	name: "Tekton",
+	match: func(fileName string) bool {
+		return strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml")
+	},
+	images: tektonImages,
+}
+
+// tektonAPIVersionRegex matches the apiVersion of the Tekton resources, i.e. tekton.dev/v1
+var tektonAPIVersionRegex = regexp.MustCompile(`(?m)^apiVersion:\s*["']?tekton\.dev/`)
+
+// isTektonResource returns true if the path is a YAML file declaring a Tekton resource. Their file names aren't
+// distinctive, so the content is checked instead
+func isTektonResource(path string) bool {
+	fileName := filepath.Base(path)
+	if !strings.HasSuffix(fileName, ".yml") && !strings.HasSuffix(fileName, ".yaml") {
+		return false
+	}
+	content, err := os.ReadFile(path) // nolint:gosec
+	return err == nil && tektonAPIVersionRegex.Match(content)
+}
+
+// tektonImages returns the images of a Tekton Task, ClusterTask, Pipeline, TaskRun or PipelineRun. Other resources,
+// i.e. the Kubernetes manifests found along them, are skipped
+func tektonImages(doc *yaml.Node) []*yaml.Node {
+	apiVersion, kind := mappingValue(doc, "apiVersion"), mappingValue(doc, "kind")
+	if apiVersion == nil || kind == nil || !strings.HasPrefix(apiVersion.Value, "tekton.dev/") {
+		return nil
+	}
+	spec := mappingValue(doc, "spec")
+	switch kind.Value {
+	case "Task", "ClusterTask":
+		return taskSpecImages(spec)
+	case "Pipeline":
+		return pipelineSpecImages(spec)
+	case "TaskRun":
+		return taskSpecImages(mappingValue(spec, "taskSpec"))
+	case "PipelineRun":
+		return pipelineSpecImages(mappingValue(spec, "pipelineSpec"))
+	}
+	return nil
+}
+
+// taskSpecImages returns the images of the step template, the steps and the sidecars of a Tekton task spec
+func taskSpecImages(spec *yaml.Node) []*yaml.Node {
+	nodes := imageNodes(mappingValue(mappingValue(spec, "stepTemplate"), "image"), "")
+	for _, key := range []string{"steps", "sidecars"} {
+		if containers := mappingValue(spec, key); containers != nil && containers.Kind == yaml.SequenceNode {
+			for _, c := range containers.Content {
+				nodes = append(nodes, imageNodes(mappingValue(c, "image"), "")...)
+			}
+		}
+	}
+	return nodes
+}
+
+// pipelineSpecImages returns the images of the tasks embedded in a Tekton pipeline spec, the referenced tasks being
+// pinned where they are defined
+func pipelineSpecImages(spec *yaml.Node) []*yaml.Node {
+	var nodes []*yaml.Node
+	for _, key := range []string{"tasks", "finally"} {
+		if tasks := mappingValue(spec, key); tasks != nil && tasks.Kind == yaml.SequenceNode {
+			for _, task := range tasks.Content {
+				nodes = append(nodes, taskSpecImages(mappingValue(task, "taskSpec"))...)
+			}
+		}
+	}
+	return nodes
+}
+
+// imageNodes returns the image reference of a node set either to the image or to a mapping with the image under the
+// given key
+func imageNodes(node *yaml.Node, key string) []*yaml.Node {
+	if node != nil && node.Kind == yaml.MappingNode {
+		node = mappingValue(node, key)
+	}
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return nil
+	}
+	return []*yaml.Node{node}
+}
+
+// mappingValue returns the value of the key of the mapping, or nil if it's not a mapping or doesn't have the key
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlReference is a reference found in a YAML document, along with the function pinning it
+type yamlReference struct {
+	node *yaml.Node
+	pin  func(ctx context.Context, ref string) (string, bool)
+}
+
+// pinYAMLReferences pins the image and orb references the walker finds in each document of the YAML content. The
+// references are replaced in place on their lines, so comments and formatting are preserved. Values using variables
+// or spanning several lines are skipped. It returns the updated content and whether any reference was pinned
+func (fa *FrizbeeAction) pinYAMLReferences(ctx context.Context, content string, w lineWalker) (string, bool) {
+	var refs []yamlReference
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Warning: failed to parse %s configuration: %v", w.name, err)
+			}
+			break
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		for _, n := range w.images(doc.Content[0]) {
+			refs = append(refs, yamlReference{n, fa.pinImage})
+		}
+		if w.orbs != nil {
+			for _, n := range w.orbs(doc.Content[0]) {
+				refs = append(refs, yamlReference{n, fa.pinOrb})
+			}
+		}
+	}
+	// Replace the references starting from the end, so the columns of the preceding ones on a line stay valid
+	slices.SortFunc(refs, func(a, b yamlReference) int {
+		if a.node.Line != b.node.Line {
+			return b.node.Line - a.node.Line
+		}
+		return b.node.Column - a.node.Column
+	})
+
+	lines := strings.Split(content, "\n")
+	modified := false
+	for _, ref := range refs {
+		n := ref.node
+		if n.Value == "" || strings.ContainsAny(n.Value, "$ \t\n") || n.Line > len(lines) {
+			continue
+		}
+		line := lines[n.Line-1]
+		offset := min(n.Column-1, len(line))
+		start := strings.Index(line[offset:], n.Value)
+		if start < 0 {
+			continue
+		}
+		start += offset
+		pinned, ok := ref.pin(ctx, n.Value)
+		if !ok {
+			continue
+		}
+		lines[n.Line-1] = line[:start] + pinned + line[start+len(n.Value):]
+		modified = true
+	}
+	return strings.Join(lines, "\n"), modified
+}
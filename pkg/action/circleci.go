@@ -0,0 +1,209 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// orbReferenceType is the type of the CircleCI orb references
+	orbReferenceType = "orb"
+	// circleCIGraphQLURL is the GraphQL API of CircleCI listing the versions of the orbs
+	circleCIGraphQLURL = "https://circleci.com/graphql-unstable"
+	// orbVersionsQuery is the GraphQL query of the versions of an orb
+	orbVersionsQuery = `query($name: String!) { orb(name: $name) { versions(count: 200) { version } } }`
+)
+
+var (
+	// orbKeyRegex matches the lines declaring an orb, i.e. `node: circleci/node@5`, which can't be told apart from
+	// the images by their value
+	orbKeyRegex = regexp.MustCompile(`^\s*[\w-]+:\s*["']?[\w-]+/[\w-]+@(?:\d|volatile)`)
+	// orbVersionRegex matches the versions of the orbs which can be resolved to an exact version, i.e. 5, 5.1 or
+	// volatile for the latest version
+	orbVersionRegex = regexp.MustCompile(`^(?:\d+(?:\.\d+){0,2}|volatile)$`)
+)
+
+// circleCIWalker returns the walker pinning the images of the `docker` executors of the CircleCI configurations, in
+// the jobs and the executors, and the versions of their orbs to exact versions if pinOrbs is set
+func circleCIWalker(pinOrbs bool) lineWalker {
+	w := lineWalker{
+		name: "CircleCI",
+		match: func(fileName string) bool {
+			return strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml")
+		},
+		images: circleCIImages,
+	}
+	if pinOrbs {
+		w.orbs = circleCIOrbs
+	}
+	return w
+}
+
+// isCircleCIConfig returns true if the path is a CircleCI configuration
+func isCircleCIConfig(path string) bool {
+	fileName := filepath.Base(path)
+	return strings.Contains(filepath.ToSlash(path), ".circleci/") &&
+		(strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml"))
+}
+
+// circleCIImages returns the images of the `docker` executors of the jobs and the executors of a CircleCI
+// configuration
+func circleCIImages(doc *yaml.Node) []*yaml.Node {
+	var nodes []*yaml.Node
+	for _, section := range []string{"jobs", "executors"} {
+		entries := mappingValue(doc, section)
+		if entries == nil || entries.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 1; i < len(entries.Content); i += 2 {
+			docker := mappingValue(entries.Content[i], "docker")
+			if docker == nil || docker.Kind != yaml.SequenceNode {
+				continue
+			}
+			for _, container := range docker.Content {
+				if container.Kind == yaml.MappingNode {
+					nodes = append(nodes, imageNodes(container, "image")...)
+				}
+			}
+		}
+	}
+	return nodes
+}
+
+// circleCIOrbs returns the orbs of a CircleCI configuration, leaving out the inline orbs
+func circleCIOrbs(doc *yaml.Node) []*yaml.Node {
+	var nodes []*yaml.Node
+	if orbs := mappingValue(doc, "orbs"); orbs != nil && orbs.Kind == yaml.MappingNode {
+		for i := 1; i < len(orbs.Content); i += 2 {
+			if orbs.Content[i].Kind == yaml.ScalarNode {
+				nodes = append(nodes, orbs.Content[i])
+			}
+		}
+	}
+	return nodes
+}
+
+// pinOrb resolves the version of the orb reference to the latest exact version it matches with the CircleCI API,
+// i.e. circleci/node@5 to circleci/node@5.2.0, returning the pinned reference and whether it was pinned. Orbs which
+// are already pinned, development versions and orbs failing to resolve are skipped
+func (fa *FrizbeeAction) pinOrb(ctx context.Context, ref string) (string, bool) {
+	orb, version, ok := strings.Cut(ref, "@")
+	if !ok || !orbVersionRegex.MatchString(version) || strings.Count(version, ".") == 2 {
+		return "", false
+	}
+	versions, err := fa.orbVersions(ctx, orb)
+	if err != nil {
+		log.Printf("Warning: failed to get the versions of orb %s: %v", orb, err)
+		return "", false
+	}
+	var latest []int
+	var pinned string
+	for _, v := range versions {
+		if version != "volatile" && !strings.HasPrefix(v, version+".") {
+			continue
+		}
+		if parsed, ok := parseOrbVersion(v); ok && slices.Compare(parsed, latest) > 0 {
+			latest, pinned = parsed, v
+		}
+	}
+	if pinned == "" {
+		log.Printf("Warning: no version of orb %s matches %s", orb, version)
+		return "", false
+	}
+	return orb + "@" + pinned, true
+}
+
+// orbVersions returns the published versions of the orb, caching them for the run
+func (fa *FrizbeeAction) orbVersions(ctx context.Context, orb string) ([]string, error) {
+	fa.orbMu.Lock()
+	defer fa.orbMu.Unlock()
+	if versions, ok := fa.orbCache[orb]; ok {
+		return versions, nil
+	}
+
+	body, err := json.Marshal(map[string]any{"query": orbVersionsQuery, "variables": map[string]string{"name": orb}})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, circleCIGraphQLURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Transport: remote.DefaultTransport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var result struct {
+		Data struct {
+			Orb *struct {
+				Versions []struct {
+					Version string `json:"version"`
+				} `json:"versions"`
+			} `json:"orb"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Data.Orb == nil {
+		return nil, fmt.Errorf("orb not found")
+	}
+	versions := make([]string, 0, len(result.Data.Orb.Versions))
+	for _, v := range result.Data.Orb.Versions {
+		versions = append(versions, v.Version)
+	}
+	if fa.orbCache == nil {
+		fa.orbCache = make(map[string][]string)
+	}
+	fa.orbCache[orb] = versions
+	return versions, nil
+}
+
+// parseOrbVersion parses the exact version of an orb into its major, minor and patch numbers
+func parseOrbVersion(version string) ([]int, bool) {
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	parsed := make([]int, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, false
+		}
+		parsed = append(parsed, n)
+	}
+	return parsed, true
+}
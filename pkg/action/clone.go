@@ -0,0 +1,70 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+// cloneRepository clones the base branch of the repository, or its default branch, and switches to the clone, so a
+// repository other than the one of the workflow can be scanned and committed to. The paths of the outputs are made
+// absolute first, so they are still written relative to the working directory. It returns a function switching back
+// to the working directory and removing the clone
+func (fa *FrizbeeAction) cloneRepository(ctx context.Context) (func(), error) {
+	fa.resolveBaseBranch(ctx)
+	if err := fa.absoluteOutputs(); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "frizbee-clone-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clone directory: %w", err)
+	}
+	remote := fmt.Sprintf("https://%s/%s/%s.git", pull_request.Host, fa.RepoOwner, fa.RepoName)
+	if err := pull_request.Clone(remote, fa.BaseBranch, dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	restore := func() {
+		if err := os.Chdir(wd); err != nil {
+			log.Printf("Warning: failed to switch back to %s: %v", wd, err)
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Warning: failed to remove the clone of %s/%s: %v", fa.RepoOwner, fa.RepoName, err)
+		}
+	}
+	if fa.HeadSHA, err = pull_request.HeadSHA(); err != nil {
+		restore()
+		return nil, fmt.Errorf("failed to get the cloned commit: %w", err)
+	}
+	log.Printf("Cloned %s/%s at %s", fa.RepoOwner, fa.RepoName, fa.HeadSHA)
+	return restore, nil
+}
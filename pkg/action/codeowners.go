@@ -0,0 +1,145 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// codeownersFiles are the locations of the CODEOWNERS file, in the order GitHub looks them up
+var codeownersFiles = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// codeownersRule is a line of the CODEOWNERS file, giving the owners of the files matching its pattern
+type codeownersRule struct {
+	// pattern matches the paths of the owned files
+	pattern *regexp.Regexp
+	// owners are the users and teams owning the files, i.e. @user or @org/team
+	owners []string
+}
+
+// readCodeowners reads the rules of the CODEOWNERS file of the repository, or none if it has none
+func readCodeowners() ([]codeownersRule, error) {
+	for _, path := range codeownersFiles {
+		f, err := os.Open(path) // nolint:gosec
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer f.Close() // nolint:errcheck
+
+		var rules []codeownersRule
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			pattern, err := codeownersPattern(fields[0])
+			if err != nil {
+				log.Printf("Warning: skipping the invalid pattern %s of %s: %v", fields[0], path, err)
+				continue
+			}
+			var owners []string
+			for _, owner := range fields[1:] {
+				if strings.HasPrefix(owner, "#") {
+					break
+				}
+				owners = append(owners, owner)
+			}
+			rules = append(rules, codeownersRule{pattern: pattern, owners: owners})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		return rules, nil
+	}
+	return nil, nil
+}
+
+// codeownersPattern compiles the gitignore-like pattern of a CODEOWNERS rule into a regex matching the paths of the
+// files it owns, relative to the root of the repository. Patterns without a slash but at the end match at any depth,
+// and patterns matching a directory match all the files under it, except for a trailing /*
+func codeownersPattern(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.Trim(pattern, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	// The files nested under the directories matched by a trailing * are not owned
+	if !strings.HasSuffix(pattern, "/*") || strings.HasSuffix(pattern, "**") {
+		b.WriteString("(?:/.*)?")
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// codeownersReviewers returns the users and the slugs of the teams of the organization owning the repository which
+// own the files according to the CODEOWNERS file, the last matching rule taking precedence. The owners given by
+// email and the teams of other organizations can't be requested for review, so they are left out
+func (fa *FrizbeeAction) codeownersReviewers(files []string) ([]string, []string, error) {
+	rules, err := readCodeowners()
+	if err != nil {
+		return nil, nil, err
+	}
+	var users, teams []string
+	for _, file := range files {
+		var owners []string
+		for _, rule := range rules {
+			if rule.pattern.MatchString(file) {
+				owners = rule.owners
+			}
+		}
+		for _, owner := range owners {
+			org, team, isTeam := strings.Cut(strings.TrimPrefix(owner, "@"), "/")
+			switch {
+			case !strings.HasPrefix(owner, "@"):
+				continue
+			case isTeam && strings.EqualFold(org, fa.RepoOwner) && !slices.Contains(teams, team):
+				teams = append(teams, team)
+			case !isTeam && !slices.Contains(users, org):
+				users = append(users, org)
+			}
+		}
+	}
+	return users, teams, nil
+}
@@ -0,0 +1,72 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// CommentStyleInline records the original tag of a pin in a comment at the end of its line, i.e. `# v4`
+	CommentStyleInline = "inline"
+	// CommentStyleAbove records the original tag of a pin in a comment on the line preceding it
+	CommentStyleAbove = "above"
+	// CommentStyleStructured records the original tag of a pin in a comment at the end of its line, marked as
+	// written by frizbee, i.e. `# frizbee: v4`
+	CommentStyleStructured = "structured"
+	// structuredCommentPrefix is the prefix of the tag in the comments of the structured style
+	structuredCommentPrefix = "frizbee: "
+)
+
+// pinCommentRegex matches a line pinned to a commit SHA or digest followed by a comment with its tag, capturing the
+// indentation, the `- ` of a list item, the pinned line and the tag
+var pinCommentRegex = regexp.MustCompile(
+	`^(\s*)(-\s+)?(.*@(?:[0-9a-f]{40}|sha256:[0-9a-f]{64})["']?)\s+#\s*(?:frizbee:\s*)?(\S+)\s*$`)
+
+// withCommentStyle moves the tag comments of the lines pinned in the content according to the CommentStyle. Lines
+// which were already pinned are left untouched, so running again never duplicates the comments
+func (fa *FrizbeeAction) withCommentStyle(original, content string) string {
+	if fa.CommentStyle == "" || fa.CommentStyle == CommentStyleInline {
+		return content
+	}
+	originalLines := strings.Split(original, "\n")
+	lines := strings.Split(content, "\n")
+	if len(originalLines) != len(lines) {
+		return content
+	}
+
+	styled := make([]string, 0, len(lines))
+	for i, line := range lines {
+		m := pinCommentRegex.FindStringSubmatch(strings.TrimSuffix(line, "\r"))
+		if line == originalLines[i] || m == nil {
+			styled = append(styled, line)
+			continue
+		}
+		eol := ""
+		if strings.HasSuffix(line, "\r") {
+			eol = "\r"
+		}
+		indent, item, pinned, tag := m[1], m[2], m[3], m[4]
+		switch fa.CommentStyle {
+		case CommentStyleAbove:
+			styled = append(styled, indent+"# "+tag+eol, indent+item+pinned+eol)
+		case CommentStyleStructured:
+			styled = append(styled, indent+item+pinned+" # "+structuredCommentPrefix+tag+eol)
+		}
+	}
+	return strings.Join(styled, "\n")
+}
@@ -0,0 +1,454 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/replacer/image"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+const (
+	// CommitGranularityAll commits all the changes at once
+	CommitGranularityAll = "all"
+	// CommitGranularityPerFile commits the changes of each modified file separately
+	CommitGranularityPerFile = "per-file"
+	// CommitGranularityPerDependency commits the pins of each dependency separately, across all the files
+	CommitGranularityPerDependency = "per-dependency"
+	// defaultCommitPrefix is the prefix of the default commit subjects, replaced with the conventional commit type
+	defaultCommitPrefix = "frizbee: "
+)
+
+var (
+	// commitTypeRegex matches a conventional commit type, i.e. chore or fix
+	commitTypeRegex = regexp.MustCompile(`^\w+$`)
+	// commitCategories are the categories of the changes, in the order they are committed, by reference type
+	commitCategories = []commitCategory{
+		{actions.ReferenceType, "actions"},
+		{image.ReferenceType, "images"},
+		{orbReferenceType, "orbs"},
+	}
+)
+
+// commitCategory is a category of the changes, i.e. the pinned actions or images
+type commitCategory struct {
+	// refType is the reference type of the findings of the category
+	refType string
+	// category is the name of the category in the commit messages and pull requests
+	category string
+}
+
+// ParseCommitScopeLabels parses the text/template of the messages of the commits of each category, and checks that
+// it renders the category, so the commits of the different categories can be told apart
+func ParseCommitScopeLabels(text string) (*template.Template, error) {
+	t, err := ParseCommitMessageTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	var actionsMessage, imagesMessage strings.Builder
+	if err := t.Execute(&actionsMessage, CommitMessageData{Category: "actions"}); err != nil {
+		return nil, err
+	}
+	if err := t.Execute(&imagesMessage, CommitMessageData{Category: "images"}); err != nil {
+		return nil, err
+	}
+	if actionsMessage.String() == imagesMessage.String() {
+		return nil, fmt.Errorf("commit message template %q must contain {{.Category}}", text)
+	}
+	return t, nil
+}
+
+// ValidateCommitType checks that the conventional commit type is a single word and the scope, which requires a type,
+// has no parentheses
+func ValidateCommitType(commitType, scope string) error {
+	switch {
+	case commitType == "" && scope != "":
+		return fmt.Errorf("the commit scope %q requires a commit type", scope)
+	case commitType != "" && !commitTypeRegex.MatchString(commitType):
+		return fmt.Errorf("the commit type %q must be a single word, i.e. chore", commitType)
+	case strings.ContainsAny(scope, "()\n"):
+		return fmt.Errorf("the commit scope %q can't contain parentheses or newlines", scope)
+	}
+	return nil
+}
+
+// scopedCommits splits the changes into one commit per category of the given findings, i.e. the actions first and
+// then the images, with their message rendered from the CommitTemplate. The files are committed with the content each
+// category leads to, the last commit restoring their current content, which may have been changed by the hooks.
+// Files whose number of lines changed are committed whole with the category of their first finding
+func (fa *FrizbeeAction) scopedCommits(findings []Finding) ([]pull_request.Commit, error) {
+	lineTypes, fileTypes := lineCategories(findings)
+	current := make(map[string]string, len(fa.changes))
+	for _, c := range fa.changes {
+		content, err := os.ReadFile(c.Path) // nolint:gosec
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", c.Path, err)
+		}
+		current[c.Path] = string(content)
+	}
+
+	var commits []pull_request.Commit
+	applied := make(map[string]bool)
+	for _, cat := range commitCategories {
+		applied[cat.refType] = true
+		files := make(map[string]string)
+		var names []string
+		for _, c := range fa.changes {
+			content, changed := partialContent(c, lineTypes[c.Path], fileTypes[c.Path], cat.refType, applied)
+			if !changed {
+				continue
+			}
+			names = append(names, filepath.ToSlash(c.Path))
+			files[c.Path] = content
+		}
+		if len(files) == 0 {
+			continue
+		}
+		message, err := fa.scopedMessage([]string{cat.category}, names, countFindings(findings, func(f Finding) bool {
+			return f.Type == cat.refType
+		}))
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, pull_request.Commit{Message: fa.withProvenance(message), Files: files})
+	}
+	// Leave the files with their current content in the last commit
+	if len(commits) > 0 {
+		for _, c := range fa.changes {
+			commits[len(commits)-1].Files[c.Path] = current[c.Path]
+		}
+	}
+	return commits, nil
+}
+
+// scopedMessage renders the CommitTemplate with the categories, comma-separated, files and number of pinned references
+// of a commit
+func (fa *FrizbeeAction) scopedMessage(categories, files []string, count int) (string, error) {
+	var b strings.Builder
+	data := CommitMessageData{Category: strings.Join(categories, ", "), Files: files, Count: count}
+	if err := fa.CommitTemplate.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to execute the commit scope labels template: %w", err)
+	}
+	message := strings.TrimSpace(b.String())
+	if message == "" {
+		return "", fmt.Errorf("the commit scope labels template resulted in an empty message")
+	}
+	return message, nil
+}
+
+// countFindings returns the number of the given findings matching the predicate
+func countFindings(findings []Finding, match func(Finding) bool) int {
+	count := 0
+	for _, f := range findings {
+		if match(f) {
+			count++
+		}
+	}
+	return count
+}
+
+// findingCategories returns the names of the categories of the given reference types, in the order they are committed
+func findingCategories(refTypes []string) []string {
+	var categories []string
+	for _, cat := range commitCategories {
+		if slices.Contains(refTypes, cat.refType) {
+			categories = append(categories, cat.category)
+		}
+	}
+	return categories
+}
+
+// lineCategories returns the category of each changed line by file, and the category of the first finding of each
+// file
+func lineCategories(findings []Finding) (map[string]map[int]string, map[string]string) {
+	lineTypes := make(map[string]map[int]string)
+	fileTypes := make(map[string]string)
+	for _, f := range findings {
+		if lineTypes[f.File] == nil {
+			lineTypes[f.File] = make(map[int]string)
+			fileTypes[f.File] = f.Type
+		}
+		lineTypes[f.File][f.Line] = f.Type
+	}
+	return lineTypes, fileTypes
+}
+
+// partialContent returns the content of the changed file with the changes of the applied keys only, i.e. categories
+// or dependencies, and whether the changes of the given key changed it. The lines without a key are committed with
+// the first category
+func partialContent(c fileChange, lineKeys map[int]string, fileKey, key string,
+	applied map[string]bool) (string, bool) {
+	originalLines := strings.Split(c.Original, "\n")
+	modifiedLines := strings.Split(c.Modified, "\n")
+	if len(originalLines) != len(modifiedLines) {
+		if fileKey == "" {
+			fileKey = commitCategories[0].refType
+		}
+		if !applied[fileKey] {
+			return c.Original, false
+		}
+		return c.Modified, fileKey == key
+	}
+
+	lines := make([]string, len(originalLines))
+	changed := false
+	for i := range originalLines {
+		lines[i] = originalLines[i]
+		if originalLines[i] == modifiedLines[i] {
+			continue
+		}
+		lineKey, ok := lineKeys[i+1]
+		if !ok {
+			lineKey = commitCategories[0].refType
+		}
+		if applied[lineKey] {
+			lines[i] = modifiedLines[i]
+			changed = changed || lineKey == key
+		}
+	}
+	return strings.Join(lines, "\n"), changed
+}
+
+// dependencyCommits splits the changes into one commit per pinned dependency, in the order they were found, each one
+// pinning the dependency in all the files referencing it, with the message rendered from the CommitTemplate if set,
+// with the category of the dependency. The last commit restores the current content of the files,
+// which may have been changed by the hooks. Files whose number of lines changed are committed whole with their first
+// dependency
+func (fa *FrizbeeAction) dependencyCommits(findings []Finding) ([]pull_request.Commit, error) {
+	// Get the dependency of each changed line
+	lineDeps := make(map[string]map[int]string)
+	fileDeps := make(map[string]string)
+	var deps []string
+	depTypes := make(map[string]string)
+	for _, f := range findings {
+		dep := findingRef(f.Original)
+		depTypes[dep] = f.Type
+		if lineDeps[f.File] == nil {
+			lineDeps[f.File] = make(map[int]string)
+			fileDeps[f.File] = dep
+		}
+		lineDeps[f.File][f.Line] = dep
+		if !slices.Contains(deps, dep) {
+			deps = append(deps, dep)
+		}
+	}
+
+	current := make(map[string]string, len(fa.changes))
+	for _, c := range fa.changes {
+		content, err := os.ReadFile(c.Path) // nolint:gosec
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", c.Path, err)
+		}
+		current[c.Path] = string(content)
+	}
+
+	var commits []pull_request.Commit
+	applied := make(map[string]bool)
+	for _, dep := range deps {
+		applied[dep] = true
+		files := make(map[string]string)
+		var names []string
+		for _, c := range fa.changes {
+			content, changed := partialContent(c, lineDeps[c.Path], fileDeps[c.Path], dep, applied)
+			if !changed {
+				continue
+			}
+			files[c.Path] = content
+			names = append(names, filepath.ToSlash(c.Path))
+		}
+		if len(files) == 0 {
+			continue
+		}
+		var message strings.Builder
+		switch {
+		case fa.CommitTemplate != nil:
+			scoped, err := fa.scopedMessage(findingCategories([]string{depTypes[dep]}), names,
+				countFindings(findings, func(f Finding) bool { return findingRef(f.Original) == dep }))
+			if err != nil {
+				return nil, err
+			}
+			message.WriteString(scoped)
+		case fa.CommitType != "":
+			fmt.Fprintf(&message, "%s\n", fa.withCommitType(pinSubject(depTypes[dep], dep)))
+		default:
+			fmt.Fprintf(&message, "frizbee: pin %s\n", dep)
+		}
+		if fa.CommitTemplate == nil {
+			message.WriteString("\n")
+			for _, name := range names {
+				fmt.Fprintf(&message, "- %s\n", name)
+			}
+		}
+		commits = append(commits, pull_request.Commit{
+			Message: fa.withProvenance(strings.TrimSuffix(message.String(), "\n")),
+			Files:   files,
+		})
+	}
+	// Leave the files with their current content in the last commit
+	if len(commits) > 0 {
+		for _, c := range fa.changes {
+			commits[len(commits)-1].Files[c.Path] = current[c.Path]
+		}
+	}
+	return commits, nil
+}
+
+// fileCommits splits the changes into one commit per modified file, in the order they were modified, with a message
+// naming the file and listing the references pinned in it, or rendered from the CommitTemplate if set. The files are
+// committed with their current content, which may have been changed by the hooks, the last commit also including any
+// other change
+func (fa *FrizbeeAction) fileCommits(findings []Finding) ([]pull_request.Commit, error) {
+	refs := make(map[string][]string)
+	refTypes := make(map[string][]string)
+	for _, f := range findings {
+		ref := findingRef(f.Original)
+		if !slices.Contains(refs[f.File], ref) {
+			refs[f.File] = append(refs[f.File], ref)
+		}
+		if !slices.Contains(refTypes[f.File], f.Type) {
+			refTypes[f.File] = append(refTypes[f.File], f.Type)
+		}
+	}
+
+	commits := make([]pull_request.Commit, 0, len(fa.changes))
+	for _, c := range fa.changes {
+		content, err := os.ReadFile(c.Path) // nolint:gosec
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", c.Path, err)
+		}
+		var message strings.Builder
+		if fa.CommitTemplate != nil {
+			// Name the categories of the file, the template setting the whole message
+			scoped, err := fa.scopedMessage(findingCategories(refTypes[c.Path]), []string{filepath.ToSlash(c.Path)},
+				countFindings(findings, func(f Finding) bool { return f.File == c.Path }))
+			if err != nil {
+				return nil, err
+			}
+			message.WriteString(scoped)
+		} else {
+			fmt.Fprintf(&message, "%s\n", fa.withCommitType("frizbee: pin the references in "+
+				filepath.ToSlash(c.Path)))
+		}
+		if fa.CommitTemplate == nil && len(refs[c.Path]) > 0 {
+			message.WriteString("\n")
+			for _, ref := range refs[c.Path] {
+				fmt.Fprintf(&message, "- %s\n", ref)
+			}
+		}
+		commits = append(commits, pull_request.Commit{
+			Message: fa.withProvenance(strings.TrimSuffix(message.String(), "\n")),
+			Files:   map[string]string{c.Path: string(content)},
+		})
+	}
+	return commits, nil
+}
+
+// CommitMessageData is the data the commit message and commit scope labels templates are executed with
+type CommitMessageData struct {
+	// Category is the category of the changes of the commit, i.e. actions or images, comma-separated if several, and
+	// empty for the commit with all the changes unless the pull requests are split by category
+	Category string
+	// Files is the list of the files changed by the commit
+	Files []string
+	// Count is the number of references pinned by the commit
+	Count int
+}
+
+// ParseCommitMessageTemplate parses the text/template of the commit message, and executes it once with empty data to
+// fail early on the unknown fields of CommitMessageData
+func ParseCommitMessageTemplate(text string) (*template.Template, error) {
+	t, err := template.New("commit message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(io.Discard, CommitMessageData{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// commitMessage returns the message of the commit with all the changes, from the template if set. The first line is
+// the subject and the rest the body
+func (fa *FrizbeeAction) commitMessage(pinned []Finding) (string, error) {
+	if fa.CommitMessageTemplate == nil {
+		// Name the dependency in the conventional commit subject if a single one was pinned
+		if pins := fa.templateData(pinned).Pins; fa.CommitType != "" && len(pins) == 1 {
+			return fa.withCommitType(pinSubject(pins[0].Type, pins[0].Original)), nil
+		}
+		switch {
+		case fa.group.category.category != "":
+			return fa.withCommitType(fmt.Sprintf(pull_request.DefaultCategoryCommitMessage,
+				fa.group.category.category)), nil
+		case fa.group.directory != "":
+			return fa.withCommitType(fmt.Sprintf(pull_request.DefaultDirectoryCommitMessage, fa.group.directory)), nil
+		case fa.group.batch > 0:
+			return fa.withCommitType(fmt.Sprintf("%s (%d/%d)", pull_request.DefaultCommitMessage, fa.group.batch,
+				fa.group.batches)), nil
+		}
+		return fa.withCommitType(pull_request.DefaultCommitMessage), nil
+	}
+	var b strings.Builder
+	data := fa.templateData(pinned)
+	err := fa.CommitMessageTemplate.Execute(&b, CommitMessageData{
+		Category: fa.group.category.category,
+		Files:    data.ModifiedFiles,
+		Count:    data.PinCount,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to execute the commit message template: %w", err)
+	}
+	message := strings.TrimSpace(b.String())
+	if message == "" {
+		return "", fmt.Errorf("the commit message template resulted in an empty message")
+	}
+	return message, nil
+}
+
+// withCommitType replaces the frizbee prefix of the default commit subject with the conventional commit type and
+// scope, if set, i.e. chore(deps)
+func (fa *FrizbeeAction) withCommitType(message string) string {
+	if fa.CommitType == "" {
+		return message
+	}
+	prefix := fa.CommitType
+	if fa.CommitScope != "" {
+		prefix += "(" + fa.CommitScope + ")"
+	}
+	return prefix + ": " + strings.TrimPrefix(message, defaultCommitPrefix)
+}
+
+// pinSubject returns the subject of the commit pinning a single dependency, i.e. "frizbee: pin actions/checkout to
+// v4.1.1 SHA"
+func pinSubject(refType, ref string) string {
+	dependency, version := splitReference(refType, findingRef(ref))
+	switch refType {
+	case actions.ReferenceType:
+		return fmt.Sprintf("%spin %s to %s SHA", defaultCommitPrefix, dependency, version)
+	case orbReferenceType:
+		return fmt.Sprintf("%spin %s %s to an exact version", defaultCommitPrefix, dependency, version)
+	}
+	return fmt.Sprintf("%spin %s to %s digest", defaultCommitPrefix, dependency, version)
+}
@@ -0,0 +1,72 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// pinnedImageRegex matches an image reference pinned to a digest
+var pinnedImageRegex = regexp.MustCompile(`[^\s"'=]+@sha256:[a-f0-9]{64}`)
+
+// confirmDigests makes sure the digests the image references of the file were pinned to are still reachable right
+// before writing it. Lines pinned to an unreachable digest are reverted, or an error is returned if the action is set
+// to fail on errors. It returns the confirmed content
+func (fa *FrizbeeAction) confirmDigests(ctx context.Context, path, original, content string) (string, error) {
+	originalLines := strings.Split(original, "\n")
+	lines := strings.Split(content, "\n")
+	if len(originalLines) != len(lines) {
+		return content, nil
+	}
+
+	for i, line := range lines {
+		if line == originalLines[i] {
+			continue
+		}
+		for _, ref := range pinnedImageRegex.FindAllString(line, -1) {
+			err := headDigest(ctx, strings.TrimPrefix(ref, "docker://"))
+			if err == nil {
+				continue
+			}
+			if fa.FailOnError {
+				return "", fmt.Errorf("digest of %s in %s is no longer reachable: %w", ref, path, err)
+			}
+			log.Printf("Warning: not pinning line %d of %s, the digest of %s is no longer reachable: %v",
+				i+1, path, ref, err)
+			lines[i] = originalLines[i]
+			break
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// headDigest sends a HEAD request for the manifest of the image reference pinned to a digest
+func headDigest(ctx context.Context, ref string) error {
+	digest, err := name.NewDigest(ref)
+	if err != nil {
+		return err
+	}
+	_, err = remote.Head(digest, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	return err
+}
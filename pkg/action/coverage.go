@@ -0,0 +1,134 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// pinnedReferenceRegex matches an action pinned to a commit SHA or an image pinned to a digest
+var pinnedReferenceRegex = regexp.MustCompile(`@(?:[0-9a-f]{40}\b|sha(?:256|512):[0-9a-f]{64})`)
+
+// FileCoverage is the number of references of a file by status
+type FileCoverage struct {
+	// File is the path of the file, relative to the repository root
+	File string `json:"file"`
+	// AlreadyPinned is the number of references which were pinned before the run
+	AlreadyPinned int `json:"already_pinned"`
+	// Unpinned is the number of unpinned references which can be pinned automatically
+	Unpinned int `json:"unpinned"`
+	// Unpinnable is the number of unpinned references which can't be pinned automatically
+	Unpinnable int `json:"unpinnable"`
+}
+
+// countPinned returns the number of pinned references in the content, outside of comments
+func countPinned(content string) int {
+	count := 0
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		count += len(pinnedReferenceRegex.FindAllString(line, -1))
+	}
+	return count
+}
+
+// recordPinned records the number of references of the processed file which are already pinned, before it is
+// modified
+func (fa *FrizbeeAction) recordPinned(path string) error {
+	content, err := os.ReadFile(path) // nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	if fa.alreadyPinned == nil {
+		fa.alreadyPinned = make(map[string]int)
+	}
+	fa.alreadyPinned[path] = countPinned(string(content))
+	return nil
+}
+
+// coverage returns the number of references of each processed file by status, in the order the files were
+// processed, along with the totals
+func (fa *FrizbeeAction) coverage() ([]FileCoverage, FileCoverage) {
+	index := make(map[string]int, len(fa.processedFiles))
+	files := make([]FileCoverage, 0, len(fa.processedFiles))
+	for _, path := range fa.processedFiles {
+		index[path] = len(files)
+		files = append(files, FileCoverage{File: path, AlreadyPinned: fa.alreadyPinned[path]})
+	}
+	for _, f := range fa.findings {
+		i, ok := index[f.File]
+		if !ok {
+			i = len(files)
+			index[f.File] = i
+			files = append(files, FileCoverage{File: f.File})
+		}
+		if f.Pinned == "" {
+			files[i].Unpinnable++
+		} else {
+			files[i].Unpinned++
+		}
+	}
+
+	total := FileCoverage{File: "total"}
+	for i := range files {
+		files[i].File = filepath.ToSlash(files[i].File)
+		total.AlreadyPinned += files[i].AlreadyPinned
+		total.Unpinned += files[i].Unpinned
+		total.Unpinnable += files[i].Unpinnable
+	}
+	return files, total
+}
+
+// pinnedPercent returns the percentage of the references which are already pinned
+func (c FileCoverage) pinnedPercent() int {
+	all := c.AlreadyPinned + c.Unpinned + c.Unpinnable
+	if all == 0 {
+		return 100
+	}
+	return c.AlreadyPinned * 100 / all
+}
+
+// writeOutputs sets the outputs of the action, when running in GitHub Actions
+func (fa *FrizbeeAction) writeOutputs() error {
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		return nil
+	}
+	_, total := fa.coverage()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open outputs %s: %w", path, err)
+	}
+	defer f.Close() // nolint:errcheck
+	_, err = fmt.Fprintf(f, "already_pinned_count=%d\nunpinned_count=%d\nunpinnable_count=%d\n",
+		total.AlreadyPinned, total.Unpinned, total.Unpinnable)
+	if err != nil {
+		return fmt.Errorf("failed to write outputs %s: %w", path, err)
+	}
+	if fa.pullRequest != nil {
+		_, err = fmt.Fprintf(f, "pull_request_number=%d\npull_request_url=%s\n", fa.pullRequest.GetNumber(),
+			fa.pullRequest.GetHTMLURL())
+		if err != nil {
+			return fmt.Errorf("failed to write outputs %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/replacer/image"
+)
+
+const (
+	// defaultDockerSocket is the default path of the Docker Engine API socket
+	defaultDockerSocket = "/var/run/docker.sock"
+	// daemonTimeout is the timeout of a single request to the local daemon
+	daemonTimeout = 5 * time.Second
+)
+
+// imageRegex matches the container image references the same way the images replacer does
+var imageRegex = regexp.MustCompile(image.ContainerImageRegex)
+
+// daemonClient resolves image digests using the images already pulled by a local daemon exposing the Docker Engine
+// API (Docker, or containerd/Podman through their Docker compatible socket)
+type daemonClient struct {
+	client *http.Client
+}
+
+// newDaemonClient creates a client for the local daemon socket set in DOCKER_HOST (or the default Docker socket).
+// It returns nil if the daemon is not available, so callers can fall back to the remote registry
+func newDaemonClient(ctx context.Context) *daemonClient {
+	socket := defaultDockerSocket
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+		socket = strings.TrimPrefix(host, "unix://")
+	}
+
+	d := &daemonClient{
+		client: &http.Client{
+			Timeout: daemonTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+
+	// Make sure the daemon is reachable before using it
+	resp, err := d.get(ctx, "/_ping")
+	if err != nil {
+		log.Printf("Local daemon is not available at %s, resolving images from the registry: %v", socket, err)
+		return nil
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Local daemon at %s returned %s, resolving images from the registry", socket, resp.Status)
+		return nil
+	}
+	log.Printf("Resolving images from the local daemon at %s first", socket)
+	return d
+}
+
+// get sends a GET request for the given path to the daemon
+func (d *daemonClient) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://daemon"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.client.Do(req)
+}
+
+// digest returns the digest of the given image reference if the image is available in the local daemon,
+// or an empty string otherwise
+func (d *daemonClient) digest(ctx context.Context, imageRef string) string {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return ""
+	}
+	resp, err := d.get(ctx, fmt.Sprintf("/images/%s/json", imageRef))
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	var inspect struct {
+		RepoDigests []string `json:"RepoDigests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return ""
+	}
+	// Only use a digest that belongs to the same repository as the reference
+	for _, repoDigest := range inspect.RepoDigests {
+		d, err := name.NewDigest(repoDigest)
+		if err != nil {
+			continue
+		}
+		if d.Context().Name() == ref.Context().Name() {
+			return d.DigestStr()
+		}
+	}
+	return ""
+}
+
+// pinContent pins the image references in the content that are available in the local daemon.
+// It returns the updated content and whether any reference was pinned
+func (d *daemonClient) pinContent(ctx context.Context, content string) (string, bool) {
+	lines := strings.Split(content, "\n")
+	modified := false
+	for i, line := range lines {
+		// Skip commented lines, the same as the replacer does
+		if strings.HasPrefix(strings.TrimLeft(line, " \t\n\r"), "#") {
+			continue
+		}
+		newLine := imageRegex.ReplaceAllStringFunc(line, func(matched string) string {
+			var imageRef string
+			isFROM := strings.HasPrefix(matched, "FROM ")
+			switch {
+			case isFROM:
+				imageRef = strings.TrimPrefix(matched, "FROM ")
+			case strings.HasPrefix(matched, "image: "):
+				imageRef = strings.TrimPrefix(matched, "image: ")
+			default:
+				return matched
+			}
+			// Skip references that are already pinned or excluded
+			if strings.Contains(imageRef, "@") || imageRef == "scratch" {
+				return matched
+			}
+			ref, err := name.ParseReference(imageRef)
+			if err != nil {
+				return matched
+			}
+			digest := d.digest(ctx, imageRef)
+			if digest == "" {
+				return matched
+			}
+			// Use the same format as the images replacer
+			if isFROM {
+				return fmt.Sprintf("FROM %s:%s@%s", ref.Context().Name(), ref.Identifier(), digest)
+			}
+			return fmt.Sprintf("image: %s@%s # %s", ref.Context().Name(), digest, ref.Identifier())
+		})
+		if newLine != line {
+			lines[i] = newLine
+			modified = true
+		}
+	}
+	return strings.Join(lines, "\n"), modified
+}
+
+// parsePath pins the image references in the given path available in the local daemon, and then lets the
+// replacer resolve the remaining ones against the remote registry. The files are pinned in memory, so nothing is
+// written to disk here
+func (d *daemonClient) parsePath(ctx context.Context, r *replacer.Replacer, path string) (*replacer.ReplaceResult, error) {
+	mfs := memfs.New()
+	pinned := make(map[string]string)
+
+	// Copy the files the replacer would process to memory, pinning the images known to the daemon
+	err := filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !isYAMLOrDockerfile(entry.Name()) {
+			return nil
+		}
+		content, err := os.ReadFile(p) // nolint:gosec
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", p, err)
+		}
+		rel, err := filepath.Rel(filepath.Dir(path), p)
+		if err != nil {
+			return err
+		}
+		newContent, modified := d.pinContent(ctx, string(content))
+		if modified {
+			pinned[rel] = newContent
+		}
+		f, err := mfs.Create(rel)
+		if err != nil {
+			return fmt.Errorf("failed to copy file %s: %w", p, err)
+		}
+		defer f.Close() // nolint:errcheck
+		_, err = f.Write([]byte(newContent))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve the rest of the references against the registry
+	res, err := r.ParsePathInFS(ctx, mfs, filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	// Files which were only pinned using the daemon are not reported as modified by the replacer
+	for rel, content := range pinned {
+		if _, ok := res.Modified[rel]; !ok {
+			res.Modified[rel] = content
+		}
+	}
+	return res, nil
+}
+
+// isYAMLOrDockerfile returns true if the file name is one the replacer processes
+func isYAMLOrDockerfile(fileName string) bool {
+	return strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml") ||
+		strings.Contains(strings.ToLower(fileName), "dockerfile")
+}
@@ -0,0 +1,188 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultDiffContext is the default number of context lines around each change in a unified diff
+const DefaultDiffContext = 3
+
+// diffOp is a single line operation of a diff - ' ' for unchanged, '-' for removed and '+' for added lines
+type diffOp struct {
+	kind    byte
+	line    string
+	oldLine int
+	newLine int
+}
+
+// unifiedDiff returns the unified diff between the old and the new content of the file at path,
+// including the given number of context lines around each change
+func unifiedDiff(path, oldContent, newContent string, context int) string {
+	ops := diffLines(splitLines(oldContent), splitLines(newContent))
+
+	var b strings.Builder
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == ' ' {
+			continue
+		}
+		// Find the last change that is close enough to be part of the same hunk
+		last := i
+		for j := i + 1; j < len(ops); j++ {
+			if ops[j].kind == ' ' {
+				continue
+			}
+			if j-last-1 > 2*context {
+				break
+			}
+			last = j
+		}
+		start := max(0, i-context)
+		end := min(len(ops), last+context+1)
+
+		// Write the hunk header followed by its lines
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", path, path)
+		}
+		var oldCount, newCount int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n",
+			hunkRange(ops[start].oldLine, oldCount), hunkRange(ops[start].newLine, newCount))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&b, "%c%s\n", op.kind, op.line)
+		}
+		i = end - 1
+	}
+	return b.String()
+}
+
+// hunkRange formats the range of a hunk header from the 0-based index of its first line
+func hunkRange(first, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", first)
+	}
+	return fmt.Sprintf("%d,%d", first+1, count)
+}
+
+// diffLines computes the line operations turning the old lines into the new lines. The common prefix and suffix, most
+// of the file for a pin, are trimmed before diffing the rest with the Myers algorithm
+func diffLines(oldLines, newLines []string) []diffOp {
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, max(len(oldLines), len(newLines)))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{kind: ' ', line: oldLines[i], oldLine: i, newLine: i})
+	}
+	ops = append(ops, myersDiff(oldLines[prefix:len(oldLines)-suffix], newLines[prefix:len(newLines)-suffix],
+		prefix, prefix)...)
+	for i := suffix; i > 0; i-- {
+		oldLine, newLine := len(oldLines)-i, len(newLines)-i
+		ops = append(ops, diffOp{kind: ' ', line: oldLines[oldLine], oldLine: oldLine, newLine: newLine})
+	}
+	return ops
+}
+
+// myersDiff computes the shortest line operations turning a into b with the Myers algorithm, in O((N+M)D) time and
+// O(D^2) space for D differences, numbering the lines from the given offsets. The removed lines of a change come
+// before the added ones
+func myersDiff(a, b []string, oldOffset, newOffset int) []diffOp {
+	n, m := len(a), len(b)
+	// v[k+n+m] is the furthest x reached on the diagonal k = x - y, and trace[d] the diagonals -d to d of v after d
+	// differences, to walk the path back
+	v := make([]int, 2*(n+m)+2)
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		done := false
+		for k := -d; k <= d && !done; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+n+m] < v[k+1+n+m]) {
+				x = v[k+1+n+m]
+			} else {
+				x = v[k-1+n+m] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+n+m] = x
+			done = x >= n && y >= m
+		}
+		trace = append(trace, slices.Clone(v[n+m-d:n+m+d+1]))
+		if done {
+			break
+		}
+	}
+
+	// Walk the path back from the end, the previous diagonal of each difference being the one it was reached from
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prevX, prevY := 0, 0
+		if d > 0 {
+			prev := trace[d-1]
+			k := x - y
+			prevK := k - 1
+			if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+				prevK = k + 1
+			}
+			prevX = prev[prevK+d-1]
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{kind: ' ', line: a[x], oldLine: x + oldOffset, newLine: y + newOffset})
+		}
+		switch {
+		case d == 0:
+		case x == prevX:
+			y--
+			ops = append(ops, diffOp{kind: '+', line: b[y], oldLine: x + oldOffset, newLine: y + newOffset})
+		default:
+			x--
+			ops = append(ops, diffOp{kind: '-', line: a[x], oldLine: x + oldOffset, newLine: y + newOffset})
+		}
+	}
+	slices.Reverse(ops)
+	return ops
+}
+
+// splitLines splits the content into lines, ignoring the trailing newline
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
@@ -0,0 +1,155 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+const (
+	// DigestSHA256 is the digest algorithm the images are pinned with by default
+	DigestSHA256 = "sha256"
+	// DigestSHA512 is the digest algorithm required by stricter digest policies
+	DigestSHA512 = "sha512"
+)
+
+// manifestMediaTypes are the media types of the manifests accepted when checking if a digest is served
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// withDigestAlgorithm rewrites the sha256 digests the image references of the file were pinned to using the
+// DigestAlgorithm, as long as the registry serves the manifests by that digest too. References the registry doesn't
+// serve by the requested digest keep their sha256 digest, or an error is returned if the action is set to fail on
+// errors
+func (fa *FrizbeeAction) withDigestAlgorithm(ctx context.Context, path, original, content string) (string, error) {
+	if fa.DigestAlgorithm == "" || fa.DigestAlgorithm == DigestSHA256 {
+		return content, nil
+	}
+	if fa.digests == nil {
+		fa.digests = make(map[string]string)
+	}
+
+	originalLines := strings.Split(original, "\n")
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if i < len(originalLines) && line == originalLines[i] {
+			continue
+		}
+		for _, ref := range pinnedImageRegex.FindAllString(line, -1) {
+			ref = strings.TrimPrefix(ref, "docker://")
+			digest, ok := fa.digests[ref]
+			if !ok {
+				var err error
+				digest, err = sha512Digest(ctx, ref)
+				if err != nil {
+					if fa.FailOnError {
+						return "", fmt.Errorf("failed to pin %s in %s with a %s digest: %w", ref, path,
+							fa.DigestAlgorithm, err)
+					}
+					log.Printf("Warning: keeping the sha256 digest of %s in %s: %v", ref, path, err)
+				}
+				fa.digests[ref] = digest
+			}
+			if digest != "" {
+				sha256Digest := ref[strings.LastIndex(ref, "@")+1:]
+				lines[i] = strings.Replace(lines[i], sha256Digest, digest, 1)
+			}
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// taggedPinRegex matches an image reference pinned to a digest while keeping its tag, capturing the image, the tag
+// and the digest
+var taggedPinRegex = regexp.MustCompile(`([^\s"'=@]+):([^\s"'=@/:]+)@(sha(?:256|512):[0-9a-f]+)`)
+
+// withoutTags drops the tags the image references of the file were pinned with, leaving bare `image@digest`
+// references, if the DigestPinTagless flag is set. Only the lines pinned by the run are changed, so references pinned
+// with their tag beforehand are kept as they are
+func (fa *FrizbeeAction) withoutTags(original, content string) string {
+	if !fa.DigestPinTagless {
+		return content
+	}
+	originalLines := strings.Split(original, "\n")
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		// Keep the references which were already pinned with their tag, i.e. refreshed ones
+		if i < len(originalLines) && (line == originalLines[i] || taggedPinRegex.MatchString(originalLines[i])) {
+			continue
+		}
+		lines[i] = taggedPinRegex.ReplaceAllString(line, "$1@$3")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sha512Digest returns the sha512 digest of the manifest of the image reference pinned to a sha256 digest, after
+// making sure the registry serves the manifest by it
+func sha512Digest(ctx context.Context, ref string) (string, error) {
+	d, err := name.NewDigest(ref)
+	if err != nil {
+		return "", err
+	}
+	desc, err := remote.Get(d, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return "", err
+	}
+	sum := sha512.Sum512(desc.Manifest)
+	digest := DigestSHA512 + ":" + hex.EncodeToString(sum[:])
+
+	// The registry client only supports sha256 digests, so ask the registry for the manifest directly
+	repo := d.Context()
+	auth, err := authn.DefaultKeychain.Resolve(repo)
+	if err != nil {
+		return "", err
+	}
+	tr, err := transport.NewWithContext(ctx, repo.Registry, auth, remote.DefaultTransport,
+		[]string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", repo.Scheme(), repo.RegistryStr(), repo.RepositoryStr(), digest)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ","))
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the registry does not serve the manifest by its %s digest: %s", DigestSHA512,
+			resp.Status)
+	}
+	return digest, nil
+}
@@ -0,0 +1,160 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/stacklok/frizbee/pkg/replacer"
+)
+
+var (
+	// envLineRegex matches a `KEY=value` line of an env file, optionally prefixed with `export`
+	envLineRegex = regexp.MustCompile(`^\s*(?:export\s+)?([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*?)\s*$`)
+	// composeVariableRegex matches the `$$` escape, `${VAR}` along with its `-`, `:-`, `+`, `:+`, `?` and `:?`
+	// modifiers, and `$VAR` in a Compose file
+	composeVariableRegex = regexp.MustCompile(
+		`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-+?])([^}]*))?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+	// composeImageRegex matches the `image:` key of a Compose service whose value uses variables, its second subgroup
+	// being the image reference
+	composeImageRegex = regexp.MustCompile(`^(\s*image:\s*["']?)([^\s"'#]*\$[^\s"'#]*)`)
+)
+
+// loadEnvFile reads the variables of the env file, in the format Docker Compose reads `.env` files: `KEY=value`
+// lines, with `#` comments and optionally quoted values
+func loadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path) // nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to open env file %s: %w", path, err)
+	}
+	defer f.Close() // nolint:errcheck
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := envLineRegex.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		value := m[2]
+		switch {
+		case len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0]:
+			value = value[1 : len(value)-1]
+		case strings.Contains(value, " #"):
+			value = strings.TrimSpace(value[:strings.Index(value, " #")])
+		}
+		env[m[1]] = value
+	}
+	return env, scanner.Err()
+}
+
+// interpolate substitutes the variables of the env file in the value, the way Docker Compose does. It returns the
+// names of the variables which are not set, and have no default value
+func interpolate(value string, env map[string]string) (string, []string) {
+	var missing []string
+	result := composeVariableRegex.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		m := composeVariableRegex.FindStringSubmatch(match)
+		name, modifier, word := m[1], m[2], m[3]
+		if name == "" {
+			name = m[4]
+		}
+		v, set := env[name]
+		// The modifiers with a colon treat an empty variable as unset
+		if strings.HasPrefix(modifier, ":") && v == "" {
+			set = false
+		}
+		switch strings.TrimPrefix(modifier, ":") {
+		case "-":
+			if !set {
+				return word
+			}
+		case "+":
+			if set {
+				return word
+			}
+			return ""
+		}
+		if !set {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	return result, missing
+}
+
+// isComposeFile returns true if the file name is one of the names of a Docker Compose file
+func isComposeFile(fileName string) bool {
+	return strings.Contains(fileName, "compose") &&
+		(strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml"))
+}
+
+// pinInterpolatedImages pins the images of the Compose files of the result whose references use variables, by
+// substituting the variables of the env file and appending the digest of the resulting image to the reference. The
+// variables are kept, so the file can still be configured. References using variables which are not set in the env
+// file are skipped
+func (fa *FrizbeeAction) pinInterpolatedImages(ctx context.Context, res *replacer.ReplaceResult, path string) error {
+	for _, p := range res.Processed {
+		if !isComposeFile(filepath.Base(p)) {
+			continue
+		}
+		content, ok := res.Modified[p]
+		if !ok {
+			b, err := os.ReadFile(filepath.Join(filepath.Dir(path), p)) // nolint:gosec
+			if err != nil {
+				return fmt.Errorf("failed to read file %s: %w", p, err)
+			}
+			content = string(b)
+		}
+
+		lines := strings.Split(content, "\n")
+		changed := false
+		for i, line := range lines {
+			m := composeImageRegex.FindStringSubmatchIndex(line)
+			if m == nil {
+				continue
+			}
+			ref := line[m[4]:m[5]]
+			concrete, missing := interpolate(ref, fa.env)
+			if len(missing) > 0 {
+				log.Printf("Skipping %s in %s: %s not set in %s", ref, p, strings.Join(missing, ", "), fa.EnvFile)
+				continue
+			}
+			if strings.Contains(concrete, "@") {
+				continue
+			}
+			pinned, err := fa.ImagesReplacer.ParseString(ctx, concrete)
+			if err != nil {
+				continue
+			}
+			lines[i] = line[:m[5]] + "@" + pinned.Ref + line[m[5]:]
+			changed = true
+		}
+		if changed {
+			res.Modified[p] = strings.Join(lines, "\n")
+		}
+	}
+	return nil
+}
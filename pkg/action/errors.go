@@ -19,3 +19,7 @@ import "errors"
 
 // ErrUnpinnedFound is the error returned when unpinned actions or container images are found
 var ErrUnpinnedFound = errors.New("frizbee found unpinned actions or container images")
+
+// ErrUnresolvedFound is the error returned when some references couldn't be resolved, so not everything that could be
+// pinned was
+var ErrUnresolvedFound = errors.New("frizbee couldn't resolve some actions or container images")
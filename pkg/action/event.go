@@ -0,0 +1,91 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Event is the context of the workflow run read from the payload of the event which triggered it. Fields missing
+// from the payload, i.e. all of them for a schedule event, are left empty
+type Event struct {
+	// PRNumber is the number of the pull request, for pull_request and pull_request_target events
+	PRNumber int
+	// BaseRef is the branch the pull request targets
+	BaseRef string
+	// HeadRef is the branch the pull request comes from, or the branch pushed to for push events
+	HeadRef string
+	// HeadSHA is the head commit of the pull request, or the commit pushed for push events
+	HeadSHA string
+	// DefaultBranch is the default branch of the repository
+	DefaultBranch string
+}
+
+// eventPayload is the subset of the push, pull_request and pull_request_target event payloads used by frizbee
+type eventPayload struct {
+	Ref         string `json:"ref"`
+	After       string `json:"after"`
+	PullRequest *struct {
+		Number int `json:"number"`
+		Base   struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+		Head struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Repository *struct {
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+}
+
+// ReadEvent reads the event payload at the given path, i.e. GITHUB_EVENT_PATH. It returns an empty event if the path
+// is empty
+func ReadEvent(path string) (Event, error) {
+	var event Event
+	if path == "" {
+		return event, nil
+	}
+	content, err := os.ReadFile(path) // nolint:gosec
+	if err != nil {
+		return event, fmt.Errorf("failed to read event payload %s: %w", path, err)
+	}
+	var payload eventPayload
+	if err := json.Unmarshal(content, &payload); err != nil {
+		return event, fmt.Errorf("failed to parse event payload %s: %w", path, err)
+	}
+
+	if payload.Repository != nil {
+		event.DefaultBranch = payload.Repository.DefaultBranch
+	}
+	if pr := payload.PullRequest; pr != nil {
+		event.PRNumber = pr.Number
+		event.BaseRef = pr.Base.Ref
+		event.HeadRef = pr.Head.Ref
+		event.HeadSHA = pr.Head.SHA
+	} else if branch, ok := strings.CutPrefix(payload.Ref, "refs/heads/"); ok {
+		event.HeadRef = branch
+		// The after commit of a push deleting the branch is all zeros
+		if strings.Trim(payload.After, "0") != "" {
+			event.HeadSHA = payload.After
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,155 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+)
+
+// traceEntry is a request sent to resolve a reference
+type traceEntry struct {
+	// host and path of the request URL. The query and the headers are never recorded, so no credentials leak
+	host   string
+	path   string
+	method string
+	// result is the response status or the error of the request
+	result string
+	// used is set once the entry is attributed to a finding
+	used bool
+}
+
+// Tracer records the requests sent to resolve the references, so the resolution of each finding can be explained
+type Tracer struct {
+	mu      sync.Mutex
+	entries []*traceEntry
+}
+
+// record records a request along with its outcome
+func (t *Tracer) record(req *http.Request, resp *http.Response, err error) {
+	result := ""
+	if err != nil {
+		result = err.Error()
+	} else {
+		result = resp.Status
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(t.entries, &traceEntry{host: req.URL.Host, path: req.URL.Path, method: req.Method, result: result})
+}
+
+// tracingREST is a GitHub REST client recording its requests
+type tracingREST struct {
+	interfaces.REST
+	tracer *Tracer
+}
+
+// TraceREST wraps the GitHub REST client used by the actions replacer to record its requests
+func TraceREST(rest interfaces.REST, tracer *Tracer) interfaces.REST {
+	return &tracingREST{REST: rest, tracer: tracer}
+}
+
+// Do executes the request and records it
+func (t *tracingREST) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := t.REST.Do(ctx, req)
+	t.tracer.record(req, resp, err)
+	return resp, err
+}
+
+// tracingTransport is an HTTP transport recording the requests to container registries
+type tracingTransport struct {
+	base   http.RoundTripper
+	tracer *Tracer
+}
+
+// TraceTransport wraps the HTTP transport used to talk to container registries to record its requests
+func TraceTransport(base http.RoundTripper, tracer *Tracer) http.RoundTripper {
+	return &tracingTransport{base: base, tracer: tracer}
+}
+
+// RoundTrip executes the request and records it
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	t.tracer.record(req, resp, err)
+	return resp, err
+}
+
+// explain logs how the reference of each finding was resolved, keyed by file and line. The requests matching the
+// reference are attributed to the first finding using it, the following findings being resolved from the cache
+func (fa *FrizbeeAction) explain() {
+	if fa.Tracer == nil {
+		return
+	}
+	fa.Tracer.mu.Lock()
+	defer fa.Tracer.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("Resolution trace:\n")
+	for _, f := range append(append([]Finding(nil), fa.findings...), fa.refreshed...) {
+		if f.Pinned == "" {
+			continue
+		}
+		original := findingRef(f.Original)
+		fmt.Fprintf(&b, "%s:%d: %s -> %s\n", f.File, f.Line, original, findingRef(f.Pinned))
+		match := traceMatcher(f.Type, original)
+		hits := 0
+		for _, e := range fa.Tracer.entries {
+			if e.used || match == nil || !match(e) {
+				continue
+			}
+			e.used = true
+			hits++
+			fmt.Fprintf(&b, "  %s https://%s%s: %s\n", e.method, e.host, e.path, e.result)
+		}
+		if hits == 0 {
+			b.WriteString("  cache hit, resolved by an earlier lookup\n")
+		}
+	}
+	log.Print(b.String())
+}
+
+// traceMatcher returns a function matching the requests sent to resolve the reference, or nil if the reference can't
+// be parsed
+func traceMatcher(refType, ref string) func(e *traceEntry) bool {
+	if refType == actions.ReferenceType && !strings.HasPrefix(ref, "docker://") {
+		action, tag, ok := strings.Cut(ref, "@")
+		parts := strings.Split(action, "/")
+		if !ok || len(parts) < 2 {
+			return nil
+		}
+		prefix := fmt.Sprintf("/repos/%s/%s/git/refs/", parts[0], parts[1])
+		return func(e *traceEntry) bool {
+			return strings.HasPrefix(e.path, prefix) && strings.HasSuffix(e.path, "/"+tag)
+		}
+	}
+
+	r, err := name.ParseReference(strings.TrimPrefix(ref, "docker://"))
+	if err != nil {
+		return nil
+	}
+	repo := fmt.Sprintf("/v2/%s/", r.Context().RepositoryStr())
+	return func(e *traceEntry) bool {
+		return e.host == r.Context().RegistryStr() && strings.HasPrefix(e.path, repo)
+	}
+}
@@ -0,0 +1,150 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// handoffVersion is the version of the format of the result callback file. It must be bumped whenever a change to
+// the format would make a newer or older version of the action apply the changes differently
+const handoffVersion = 1
+
+// Handoff is the result of a scan written to the result callback file, with everything needed to apply its changes
+// in a later run without resolving the references again
+type Handoff struct {
+	// Version is the version of the format of the file
+	Version int `json:"version"`
+	// Repository is the `owner/name` of the scanned repository
+	Repository string `json:"repository"`
+	// HeadSHA is the commit which was scanned
+	HeadSHA string `json:"head_sha"`
+	// Processed is the list of the files processed by frizbee
+	Processed []string `json:"processed"`
+	// AlreadyPinned is the number of references of each processed file which were already pinned
+	AlreadyPinned map[string]int `json:"already_pinned,omitempty"`
+	// Findings is the list of the unpinned references
+	Findings []Finding `json:"findings"`
+	// Refreshed is the list of the pinned references advanced because their tag moved
+	Refreshed []Finding `json:"refreshed"`
+	// Changes is the list of the modified files, along with their content before and after pinning
+	Changes []HandoffChange `json:"changes"`
+}
+
+// HandoffChange is a file modified by frizbee in a Handoff
+type HandoffChange struct {
+	// Path is the path of the file, relative to the repository root
+	Path string `json:"path"`
+	// Original is the content of the file which was scanned
+	Original string `json:"original"`
+	// Modified is the content of the file with the pinned references
+	Modified string `json:"modified"`
+}
+
+// writeHandoff writes the changes of the scan to the ResultCallbackFile
+func (fa *FrizbeeAction) writeHandoff() error {
+	h := Handoff{
+		Version:       handoffVersion,
+		Repository:    fa.RepoOwner + "/" + fa.RepoName,
+		HeadSHA:       fa.HeadSHA,
+		Processed:     fa.processedFiles,
+		AlreadyPinned: fa.alreadyPinned,
+		Findings:      fa.findings,
+		Refreshed:     fa.refreshed,
+		Changes:       make([]HandoffChange, 0, len(fa.changes)),
+	}
+	for _, c := range fa.changes {
+		h.Changes = append(h.Changes, HandoffChange{Path: c.Path, Original: c.Original, Modified: c.Modified})
+	}
+	content, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal result callback file: %w", err)
+	}
+	if err := os.WriteFile(fa.ResultCallbackFile, content, 0600); err != nil {
+		return fmt.Errorf("failed to write result callback file %s: %w", fa.ResultCallbackFile, err)
+	}
+	log.Printf("Wrote the changes to apply to %s", fa.ResultCallbackFile)
+	return nil
+}
+
+// applyHandoff applies the changes of the ApplyFromFile written by a previous run, instead of scanning. The files
+// must be within the repository and not have changed since they were scanned. They are written in place, or to the
+// OutputDir in transform only mode, and left untouched in a dry run. It returns whether any file was modified
+func (fa *FrizbeeAction) applyHandoff() (bool, error) {
+	content, err := os.ReadFile(fa.ApplyFromFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", fa.ApplyFromFile, err)
+	}
+	var h Handoff
+	if err := json.Unmarshal(content, &h); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", fa.ApplyFromFile, err)
+	}
+	if h.Version != handoffVersion {
+		return false, fmt.Errorf("%s has version %d, only version %d is supported", fa.ApplyFromFile, h.Version,
+			handoffVersion)
+	}
+	if repo := fa.RepoOwner + "/" + fa.RepoName; h.Repository != repo {
+		return false, fmt.Errorf("%s holds the changes of %s, not %s", fa.ApplyFromFile, h.Repository, repo)
+	}
+	log.Printf("Applying the changes of %s, scanned at %s", fa.ApplyFromFile, h.HeadSHA)
+
+	// Make sure all the files are within the repository and as scanned before writing any of them
+	rfs := osfs.New(".", osfs.WithBoundOS())
+	for _, c := range h.Changes {
+		if !filepath.IsLocal(c.Path) {
+			return false, fmt.Errorf("%s holds a change to %s, outside of the repository", fa.ApplyFromFile, c.Path)
+		}
+		current, err := readFile(rfs, c.Path)
+		if err != nil {
+			return false, err
+		}
+		if current != c.Original {
+			return false, fmt.Errorf("%s changed since it was scanned, scan it again", c.Path)
+		}
+	}
+
+	var modified bool
+	for _, c := range h.Changes {
+		fa.changes = append(fa.changes, fileChange(c))
+		// Only write the changes if the OpenPR or TransformOnly flag is set, like when scanning
+		switch {
+		case fa.TransformOnly && fa.OutputDir != "":
+			err = writeOutputFile(fa.OutputDir, c.Path, c.Modified)
+		case fa.OpenPR || fa.TransformOnly:
+			err = util.WriteFile(rfs, c.Path, []byte(c.Modified), 0644)
+		default:
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to write file %s: %w", c.Path, err)
+		}
+		log.Printf("Modified file: %s", c.Path)
+		modified = true
+		fa.modifiedFiles = append(fa.modifiedFiles, c.Path)
+	}
+	fa.processedFiles = h.Processed
+	fa.alreadyPinned = h.AlreadyPinned
+	fa.findings = h.Findings
+	fa.refreshed = h.Refreshed
+	return modified, nil
+}
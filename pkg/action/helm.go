@@ -0,0 +1,139 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// chartReferenceType is the type of the Helm chart dependency references
+const chartReferenceType = "chart"
+
+// exactVersionRegex matches exact semantic versions, as opposed to version constraints
+var exactVersionRegex = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// chartDependency is a dependency of a Helm chart, along with the line declaring it
+type chartDependency struct {
+	name       string
+	version    string
+	repository string
+	line       int
+}
+
+// parseHelmCharts reports the dependencies of the Helm charts in the path which are not pinned. Helm can't pin
+// dependencies to digests in `Chart.yaml`, so OCI dependencies are pinned when they use an exact version, the
+// digest being recorded in `Chart.lock`. Dependencies from HTTP chart repositories can be republished under the same
+// version, so they are always reported. The charts are never modified
+func (fa *FrizbeeAction) parseHelmCharts(path string) error {
+	log.Printf("Parsing Helm charts in %s", path)
+	return filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || entry.Name() != "Chart.yaml" {
+			return nil
+		}
+		content, err := os.ReadFile(file) // nolint:gosec
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", file, err)
+		}
+		deps, err := chartDependencies(content)
+		if err != nil {
+			return fmt.Errorf("failed to parse Helm chart %s: %w", file, err)
+		}
+		log.Printf("Processed file: %s", file)
+		fa.processedFiles = append(fa.processedFiles, file)
+
+		lines := strings.Split(string(content), "\n")
+		for _, dep := range deps {
+			reason := chartDependencyIssue(dep)
+			if reason == "" {
+				continue
+			}
+			log.Printf("Warning: %s:%d: %s", file, dep.line, reason)
+			fa.findings = append(fa.findings, Finding{
+				File:     file,
+				Line:     dep.line,
+				Type:     chartReferenceType,
+				Original: strings.TrimSpace(lines[dep.line-1]),
+				Reason:   reason,
+			})
+		}
+		return nil
+	})
+}
+
+// chartDependencyIssue returns why the chart dependency is not pinned, or an empty string if it is
+func chartDependencyIssue(dep chartDependency) string {
+	switch {
+	case dep.repository == "" || strings.HasPrefix(dep.repository, "file://"):
+		// Local charts are part of the repository
+		return ""
+	case strings.HasPrefix(dep.repository, "oci://"):
+		if exactVersionRegex.MatchString(dep.version) {
+			return ""
+		}
+		return fmt.Sprintf("chart %s uses the version constraint %q, use an exact version", dep.name, dep.version)
+	default:
+		return fmt.Sprintf("chart %s comes from the repository %s, which doesn't support pinning to a digest. "+
+			"Move it to an OCI registry or vendor it", dep.name, dep.repository)
+	}
+}
+
+// chartDependencies returns the dependencies of the chart, each along with the line of its version, or of its name
+// if there is no version
+func chartDependencies(content []byte) ([]chartDependency, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	var deps []chartDependency
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "dependencies" || root.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, item := range root.Content[i+1].Content {
+			if item.Kind != yaml.MappingNode {
+				continue
+			}
+			dep := chartDependency{line: item.Line}
+			for j := 0; j+1 < len(item.Content); j += 2 {
+				key, value := item.Content[j].Value, item.Content[j+1]
+				switch key {
+				case "name":
+					dep.name = value.Value
+				case "version":
+					dep.version = value.Value
+					dep.line = value.Line
+				case "repository":
+					dep.repository = value.Value
+				}
+			}
+			deps = append(deps, dep)
+		}
+	}
+	return deps, nil
+}
@@ -0,0 +1,144 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+)
+
+const (
+	// lockRef is the ref of the repository held by the run committing and pushing the changes, without its refs/
+	// prefix
+	lockRef = "frizbee-action/lock"
+	// lockExpiry is the age after which a lock is considered left behind by a run which failed to release it
+	lockExpiry = time.Hour
+	// lockPollInterval is the interval at which a held lock is checked again
+	lockPollInterval = 10 * time.Second
+)
+
+// acquireLock keeps the concurrent runs from committing and pushing the changes at the same time, i.e. two scheduled
+// runs racing on the same branch. The lock is a ref of the repository pointing to a commit recording the run holding
+// it, as creating a ref is atomic. A held lock is waited for up to the LockTimeout, and taken over once it expires,
+// see takeOverLock. It returns a function releasing the lock
+func (fa *FrizbeeAction) acquireLock(ctx context.Context) (func(), error) {
+	fa.resolveBaseBranch(ctx)
+	branch, _, err := fa.Client.Repositories.GetBranch(ctx, fa.RepoOwner, fa.RepoName, fa.BaseBranch, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get branch %s: %w", fa.BaseBranch, err)
+	}
+	commit, _, err := fa.Client.Git.CreateCommit(ctx, fa.RepoOwner, fa.RepoName, &github.Commit{
+		Message: github.String(fmt.Sprintf("frizbee-action lock held by run %s", fa.RunID)),
+		Tree:    &github.Tree{SHA: branch.GetCommit().GetCommit().GetTree().SHA},
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the lock commit: %w", err)
+	}
+	ref := &github.Reference{Ref: github.String("refs/" + lockRef), Object: &github.GitObject{SHA: commit.SHA}}
+
+	deadline := time.Now().Add(fa.LockTimeout)
+	for {
+		_, resp, err := fa.Client.Git.CreateRef(ctx, fa.RepoOwner, fa.RepoName, ref)
+		if err == nil {
+			break
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnprocessableEntity {
+			return nil, fmt.Errorf("failed to create the lock: %w", err)
+		}
+
+		// The lock is held, take it over if it expired
+		holder, expired, err := fa.lockHolder(ctx)
+		if err != nil {
+			return nil, err
+		}
+		held := holder.GetMessage()
+		if expired {
+			log.Printf("Warning: taking over the expired lock held by %s", held)
+			takeover, err := fa.takeOverLock(ctx, holder, commit)
+			if err != nil {
+				return nil, err
+			}
+			if takeover != nil {
+				commit = takeover
+				break
+			}
+			log.Printf("Warning: another run took over the expired lock held by %s first", held)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("another run is committing its changes (%s), retry once it's done", held)
+		}
+		log.Printf("Waiting for the lock held by %s", held)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	log.Printf("Acquired the lock %s", lockRef)
+	return func() {
+		// Only release the lock if it wasn't taken over
+		current, _, err := fa.Client.Git.GetRef(ctx, fa.RepoOwner, fa.RepoName, lockRef)
+		if err != nil || current.GetObject().GetSHA() != commit.GetSHA() {
+			return
+		}
+		if _, err := fa.Client.Git.DeleteRef(ctx, fa.RepoOwner, fa.RepoName, lockRef); err != nil {
+			log.Printf("Warning: failed to release the lock %s: %v", lockRef, err)
+		}
+	}, nil
+}
+
+// lockHolder returns the commit of the held lock, whose message names the run holding it, and whether it expired
+func (fa *FrizbeeAction) lockHolder(ctx context.Context) (*github.Commit, bool, error) {
+	current, _, err := fa.Client.Git.GetRef(ctx, fa.RepoOwner, fa.RepoName, lockRef)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get the lock: %w", err)
+	}
+	commit, _, err := fa.Client.Git.GetCommit(ctx, fa.RepoOwner, fa.RepoName, current.GetObject().GetSHA())
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get the lock commit: %w", err)
+	}
+	return commit, time.Since(commit.GetCommitter().GetDate().Time) > lockExpiry, nil
+}
+
+// takeOverLock points the lock to a new lock commit whose parent is the commit of the expired lock, without forcing
+// the update, so it only succeeds if the lock still points to the expired commit rather than to the commit of another
+// run which took it over first. It returns the new lock commit, or nil if another run took over the lock
+func (fa *FrizbeeAction) takeOverLock(ctx context.Context, expired, lock *github.Commit) (*github.Commit, error) {
+	commit, _, err := fa.Client.Git.CreateCommit(ctx, fa.RepoOwner, fa.RepoName, &github.Commit{
+		Message: lock.Message,
+		Tree:    &github.Tree{SHA: lock.GetTree().SHA},
+		Parents: []*github.Commit{{SHA: expired.SHA}},
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the lock commit: %w", err)
+	}
+	ref := &github.Reference{Ref: github.String("refs/" + lockRef), Object: &github.GitObject{SHA: commit.SHA}}
+	_, resp, err := fa.Client.Git.UpdateRef(ctx, fa.RepoOwner, fa.RepoName, ref, false)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnprocessableEntity {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to take over the lock: %w", err)
+	}
+	return commit, nil
+}
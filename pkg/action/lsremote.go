@@ -0,0 +1,122 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stacklok/frizbee/pkg/interfaces"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+// gitRefPathRegex matches the API paths the actions replacer uses to resolve tags and branches, capturing the owner,
+// the repository and the full name of the ref
+var gitRefPathRegex = regexp.MustCompile(`/repos/([^/]+)/([^/]+)/git/(refs/(?:tags|heads)/.+)$`)
+
+// lsRemoteREST is a GitHub REST client resolving tags and branches with `git ls-remote` when the API fails
+type lsRemoteREST struct {
+	interfaces.REST
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// LsRemoteFallback wraps the GitHub REST client used by the actions replacer to resolve the tags and branches of
+// public repositories with `git ls-remote` when the API is unavailable
+func LsRemoteFallback(rest interfaces.REST) interfaces.REST {
+	return &lsRemoteREST{REST: rest, cache: make(map[string]string)}
+}
+
+// Do executes the request, falling back to `git ls-remote` if it fails for another reason than the ref not existing
+func (l *lsRemoteREST) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := l.REST.Do(ctx, req)
+	if err == nil || (resp != nil && resp.StatusCode == http.StatusNotFound) || ctx.Err() != nil {
+		return resp, err
+	}
+	m := gitRefPathRegex.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		return resp, err
+	}
+
+	log.Printf("Warning: failed to resolve %s of %s/%s with the GitHub API, falling back to git ls-remote: %v",
+		m[3], m[1], m[2], err)
+	sha, lsErr := l.lsRemote(ctx, m[1], m[2], m[3])
+	if lsErr != nil {
+		log.Printf("Warning: git ls-remote fallback failed: %v", lsErr)
+		return resp, err
+	}
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+
+	// Answer like the API would, so the replacer handles the result as usual
+	if sha == "" {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+	}
+	body, err := json.Marshal(map[string]any{"ref": m[3], "object": map[string]string{"sha": sha, "type": "commit"}})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+// lsRemote returns the commit SHA the ref of the public repository of the GitHub server points to, or an empty string
+// if the ref doesn't exist. Annotated tags are peeled to the commit they point to
+func (l *lsRemoteREST) lsRemote(ctx context.Context, owner, repo, ref string) (string, error) {
+	url := fmt.Sprintf("https://%s/%s/%s", pull_request.Host, owner, repo)
+	key := url + " " + ref
+	l.mu.Lock()
+	sha, ok := l.cache[key]
+	l.mu.Unlock()
+	if ok {
+		return sha, nil
+	}
+
+	// The refs are listed anonymously, only public repositories are supported
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{url}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{PeelingOption: git.AppendPeeled})
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote %s %s: %w", url, ref, err)
+	}
+	for _, r := range refs {
+		// The peeled ref takes precedence over the tag itself
+		switch r.Name().String() {
+		case ref:
+			if sha == "" {
+				sha = r.Hash().String()
+			}
+		case ref + "^{}":
+			sha = r.Hash().String()
+		}
+	}
+
+	l.mu.Lock()
+	l.cache[key] = sha
+	l.mu.Unlock()
+	return sha, nil
+}
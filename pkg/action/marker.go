@@ -0,0 +1,110 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+
+	"github.com/google/go-github/v60/github"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+// pullRequestMarkerRegex matches the hidden comment in the body of the pull requests opened by frizbee, to find them
+// later, capturing its JSON payload. The pull requests opened by the previous versions have no payload
+var pullRequestMarkerRegex = regexp.MustCompile(`<!-- frizbee-action( \{.*?\})? -->`)
+
+// PullRequestMarker is the JSON payload of the hidden comment in the body of the pull requests opened by frizbee, so
+// the following runs and external tooling can tell what each one pins
+type PullRequestMarker struct {
+	// RunID is the ID of the workflow run which opened or last updated the pull request
+	RunID string `json:"run_id,omitempty"`
+	// ContentHash is the hash of the pins of the pull request, the same for the runs pinning the same references in
+	// the same files
+	ContentHash string `json:"content_hash"`
+	// Categories are the categories of the pinned references, i.e. actions and images
+	Categories []string `json:"categories"`
+	// Branch is the branch of the pull request
+	Branch string `json:"branch"`
+	// Base is the branch the pull request targets
+	Base string `json:"base"`
+	// BranchTemplate is the template the branch was named after, along with the group of the pull request if split,
+	// telling the pull requests of different workflows apart
+	BranchTemplate string `json:"branch_template,omitempty"`
+}
+
+// pullRequestMarker returns the JSON payload describing the pull request of the findings
+func (fa *FrizbeeAction) pullRequestMarker(pinned []Finding) PullRequestMarker {
+	findings := fa.groupedFindings(append(slices.Clone(pinned), fa.refreshed...))
+	marker := PullRequestMarker{
+		RunID:       fa.RunID,
+		ContentHash: pinsHash(findings),
+		Categories:  []string{},
+		Branch:      fa.branch,
+		Base:        fa.BaseBranch,
+	}
+	marker.BranchTemplate = pull_request.DefaultBranchName
+	if fa.BranchTemplate != nil {
+		marker.BranchTemplate = fa.BranchTemplate.Root.String()
+	}
+	if suffix := fa.group.suffix(); suffix != "" {
+		marker.BranchTemplate += "-" + suffix
+	}
+	for _, cat := range commitCategories {
+		if slices.ContainsFunc(findings, func(f Finding) bool { return f.Type == cat.refType && f.Pinned != "" }) {
+			marker.Categories = append(marker.Categories, cat.category)
+		}
+	}
+	return marker
+}
+
+// comment returns the hidden comment with the JSON payload of the marker
+func (m PullRequestMarker) comment() string {
+	// The payload can't end the comment early, as the JSON encoder escapes the > character
+	payload, _ := json.Marshal(m) // nolint:errchkjson
+	return "<!-- frizbee-action " + string(payload) + " -->"
+}
+
+// supersedes returns whether the pull request with the marker supersedes the one with the other marker, i.e. its
+// branch is named after the same template and it pins some of the same categories of references
+func (m PullRequestMarker) supersedes(other *PullRequestMarker) bool {
+	if other == nil || other.BranchTemplate != m.BranchTemplate {
+		return false
+	}
+	return slices.ContainsFunc(other.Categories, func(c string) bool { return slices.Contains(m.Categories, c) })
+}
+
+// parsePullRequestMarker returns the payload of the hidden comment in the body of a pull request opened by frizbee,
+// which is nil for the ones opened by the previous versions, and whether the body has the comment
+func parsePullRequestMarker(body string) (*PullRequestMarker, bool) {
+	m := pullRequestMarkerRegex.FindStringSubmatch(body)
+	if m == nil {
+		return nil, false
+	}
+	var marker PullRequestMarker
+	if m[1] == "" || json.Unmarshal([]byte(m[1]), &marker) != nil {
+		return nil, true
+	}
+	return &marker, true
+}
+
+// isFrizbeePullRequest returns whether the pull request was opened by frizbee
+func isFrizbeePullRequest(pr *github.PullRequest) bool {
+	_, ok := parsePullRequestMarker(pr.GetBody())
+	return ok
+}
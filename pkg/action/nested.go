@@ -0,0 +1,86 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// withKeyRegex matches lines declaring the `with:` inputs of a step, capturing the indentation of the key and an
+// inline flow mapping, if any
+var withKeyRegex = regexp.MustCompile(`^(\s*(?:-\s+)?)with:\s*(\{.*)?$`)
+
+// nestedInputRegex returns a regular expression matching the given input keys set to an action reference, its first
+// subgroup being the action reference to pin
+func nestedInputRegex(keys []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(keys))
+	for _, k := range keys {
+		quoted = append(quoted, regexp.QuoteMeta(k))
+	}
+	return regexp.MustCompile(`(?:^|[{,\s])(?:` + strings.Join(quoted, "|") +
+		`)\s*:\s*["']?([\w.-]+/[^\s"'#,}@]+@[^\s"'#,}]+)`)
+}
+
+// pinNestedActionInputs pins the action references passed to the NestedActionKeys inputs of the steps of the
+// workflow, i.e. `with: { action: owner/repo@ref }`. These references are not run as steps, so the replacer doesn't
+// pin them. Lines are only updated in place and references which fail to resolve are left untouched
+func (fa *FrizbeeAction) pinNestedActionInputs(ctx context.Context, content string) string {
+	inputRegex := nestedInputRegex(fa.NestedActionKeys)
+	lines := strings.Split(content, "\n")
+	withIndent := -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		inWith := false
+		switch m := withKeyRegex.FindStringSubmatch(line); {
+		case m != nil:
+			// The inputs of a block mapping are on the following lines, more indented than the key itself
+			withIndent = len(m[1])
+			inWith = m[2] != ""
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			continue
+		case withIndent >= 0 && indent > withIndent:
+			inWith = true
+		default:
+			withIndent = -1
+		}
+		if !inWith {
+			continue
+		}
+
+		// Replace the matched references starting from the end of the line, so the indexes stay valid
+		matches := inputRegex.FindAllStringSubmatchIndex(line, -1)
+		for j := len(matches) - 1; j >= 0; j-- {
+			start, end := matches[j][2], matches[j][3]
+			ref, err := fa.ActionsReplacer.ParseString(ctx, line[start:end])
+			if err != nil {
+				continue
+			}
+			// Keep the tag in a comment like the replacer does, unless something follows the reference
+			after := strings.TrimLeft(line[end:], `"'`)
+			pinned := fmt.Sprintf("%s@%s", ref.Name, ref.Ref) + line[end:len(line)-len(after)]
+			if strings.TrimSpace(after) == "" && ref.Tag != "" {
+				pinned += " # " + ref.Tag
+			}
+			line = line[:start] + pinned + after
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
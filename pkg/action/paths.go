@@ -0,0 +1,236 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxFiles is the default maximum number of files to scan, guarding against scanning a whole repository by
+// mistake
+const DefaultMaxFiles = 1000
+
+// walkerPath is a path to parse using a line walker
+type walkerPath struct {
+	path   string
+	walker lineWalker
+}
+
+// targets are the paths to parse, grouped by the parser handling them
+type targets struct {
+	actions []string
+	images  []string
+	walkers []walkerPath
+}
+
+// inputTargets returns the paths set by the path inputs of the action
+func (fa *FrizbeeAction) inputTargets() targets {
+	var t targets
+	if fa.ActionsPath != "" {
+		t.actions = append(t.actions, fa.workdirPath(fa.ActionsPath))
+	}
+	for _, path := range []string{fa.DockerfilesPath, fa.DockerComposePath, fa.KubernetesPath} {
+		if path != "" {
+			t.images = append(t.images, fa.workdirPath(path))
+		}
+	}
+	for _, w := range []walkerPath{
+		{fa.DevcontainerPath, devcontainerWalker},
+		{fa.QuadletPath, quadletWalker},
+		{fa.TerraformPath, terraformWalker},
+		{fa.GitLabCIPath, gitlabCIWalker},
+		{fa.CircleCIPath, circleCIWalker(fa.CircleCIPinOrbs)},
+		{fa.AzurePipelinesPath, azurePipelinesWalker},
+		{fa.BitbucketPath, bitbucketPipelinesWalker},
+		{fa.TektonPath, tektonWalker},
+		{fa.ShellScriptsPath, shellScriptWalker(fa.ShellScriptRegex)},
+	} {
+		if w.path != "" {
+			w.path = fa.workdirPath(w.path)
+			t.walkers = append(t.walkers, w)
+		}
+	}
+	return t
+}
+
+// workdirPath returns the path relative to the repository root of a path relative to the Workdir
+func (fa *FrizbeeAction) workdirPath(path string) string {
+	if fa.Workdir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(fa.Workdir, path)
+}
+
+// fileTargets returns the files listed in the OnlyPathsFile, one per line and relative to the Workdir, classified by
+// their type. Listed files which don't exist are an error, unless the AllowEmptyPaths flag is set
+func (fa *FrizbeeAction) fileTargets() (targets, error) {
+	var t targets
+	f, err := os.Open(fa.OnlyPathsFile)
+	if err != nil {
+		return t, err
+	}
+	defer f.Close() // nolint:errcheck
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		path := filepath.Clean(strings.TrimSpace(scanner.Text()))
+		if path == "." {
+			continue
+		}
+		path = fa.workdirPath(path)
+		if _, err := os.Stat(path); err != nil {
+			if fa.AllowEmptyPaths && os.IsNotExist(err) {
+				log.Printf("Skipping %s: the file does not exist", path)
+				continue
+			}
+			return t, fmt.Errorf("listed path %s: %w", path, err)
+		}
+
+		fileName := filepath.Base(path)
+		switch {
+		case isWorkflowFile(path):
+			t.actions = append(t.actions, path)
+		case devcontainerWalker.match(fileName):
+			t.walkers = append(t.walkers, walkerPath{path, devcontainerWalker})
+		case quadletWalker.match(fileName):
+			t.walkers = append(t.walkers, walkerPath{path, quadletWalker})
+		case terraformWalker.match(fileName):
+			t.walkers = append(t.walkers, walkerPath{path, terraformWalker})
+		case gitlabCIWalker.match(fileName):
+			t.walkers = append(t.walkers, walkerPath{path, gitlabCIWalker})
+		case isCircleCIConfig(path):
+			t.walkers = append(t.walkers, walkerPath{path, circleCIWalker(fa.CircleCIPinOrbs)})
+		case bitbucketPipelinesWalker.match(fileName):
+			t.walkers = append(t.walkers, walkerPath{path, bitbucketPipelinesWalker})
+		case isAzurePipelines(path):
+			t.walkers = append(t.walkers, walkerPath{path, azurePipelinesWalker})
+		case isShellScript(fileName) && fa.ShellScriptRegex != nil:
+			t.walkers = append(t.walkers, walkerPath{path, shellScriptWalker(fa.ShellScriptRegex)})
+		case isTektonResource(path):
+			t.walkers = append(t.walkers, walkerPath{path, tektonWalker})
+		case isYAMLOrDockerfile(fileName):
+			t.images = append(t.images, path)
+		default:
+			log.Printf("Skipping %s: unsupported file type", path)
+		}
+	}
+	return t, scanner.Err()
+}
+
+// files returns the targets with their directories expanded to the files in them the parsers handle, so the files
+// can be parsed one at a time
+func (t targets) files() (targets, error) {
+	var expanded targets
+	var err error
+	if expanded.actions, err = filesIn(t.actions, isYAMLOrDockerfile); err != nil {
+		return expanded, err
+	}
+	if expanded.images, err = filesIn(t.images, isYAMLOrDockerfile); err != nil {
+		return expanded, err
+	}
+	for _, w := range t.walkers {
+		files, err := filesIn([]string{w.path}, w.walker.match)
+		if err != nil {
+			return expanded, err
+		}
+		for _, f := range files {
+			expanded.walkers = append(expanded.walkers, walkerPath{f, w.walker})
+		}
+	}
+	return expanded, nil
+}
+
+// filesIn returns the files of the paths matching the file name filter, walking the directories
+func filesIn(paths []string, match func(fileName string) bool) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && (p == path || match(entry.Name())) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the files in %s: %w", path, err)
+		}
+	}
+	return files, nil
+}
+
+// isWorkflowFile returns true if the path is a GitHub Actions workflow or action metadata file
+func isWorkflowFile(path string) bool {
+	fileName := filepath.Base(path)
+	if fileName == "action.yml" || fileName == "action.yaml" {
+		return true
+	}
+	return strings.Contains(filepath.ToSlash(path), ".github/workflows/") &&
+		(strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml"))
+}
+
+// errTooManyFiles stops counting the files once there are too many of them
+var errTooManyFiles = errors.New("too many files")
+
+// checkFileCount makes sure the targets don't contain more files to scan than MaxFiles, failing as soon as the cap
+// is exceeded. A MaxFiles of zero disables the cap
+func (fa *FrizbeeAction) checkFileCount(t targets) error {
+	if fa.MaxFiles == 0 {
+		log.Printf("Warning: the number of files to scan is not capped")
+		return nil
+	}
+
+	type matchPath struct {
+		path  string
+		match func(fileName string) bool
+	}
+	var paths []matchPath
+	for _, path := range append(append([]string(nil), t.actions...), t.images...) {
+		paths = append(paths, matchPath{path, isYAMLOrDockerfile})
+	}
+	for _, w := range t.walkers {
+		paths = append(paths, matchPath{w.path, w.walker.match})
+	}
+
+	count := 0
+	for _, p := range paths {
+		err := filepath.WalkDir(p.path, func(_ string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !p.match(entry.Name()) {
+				return nil
+			}
+			if count++; count > fa.MaxFiles {
+				return errTooManyFiles
+			}
+			return nil
+		})
+		if errors.Is(err, errTooManyFiles) {
+			return fmt.Errorf("found more than %d files to scan in %s, point the path inputs to narrower "+
+				"directories or raise max_files", fa.MaxFiles, p.path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
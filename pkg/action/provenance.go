@@ -0,0 +1,93 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+const (
+	// frizbeeModule is the path of the frizbee library module
+	frizbeeModule = "github.com/stacklok/frizbee"
+	// unknownVersion is used when a version can't be determined
+	unknownVersion = "unknown"
+)
+
+// provenanceInputNames are the inputs recorded in the provenance footer: the paths scanned and the modes changing how
+// the references are pinned and applied. Anything else may embed credentials, i.e. the URL of the git remote or the
+// commands of the hooks, or span several lines, i.e. the templates, so it's never recorded
+var provenanceInputNames = []string{
+	"actions", "dockerfiles", "kubernetes", "docker_compose", "env_file", "devcontainer", "quadlet",
+	"shell_scripts", "terraform", "helm", "gitlab_ci", "circleci", "azure_pipelines", "bitbucket_pipelines",
+	"tekton", "workdir", "open_pr", "commit_to_current_branch", "commit_direct", "transform_only", "write_mode",
+	"signed_commits", "commit_granularity", "split_prs", "group_by", "image_digest_algorithm",
+	"pinning_comment_style", "digest_pin_tagless", "refresh_pins", "confirm_digest_immutability", "verify_sigstore",
+	"ignore_unresolvable", "fail_on_unpinned", "fail_on_unresolved",
+}
+
+// withProvenance appends the provenance footer to the given text if the ProvenanceFooter flag is set
+func (fa *FrizbeeAction) withProvenance(text string) string {
+	if !fa.ProvenanceFooter {
+		return text
+	}
+	return text + "\n\n" + provenanceFooter()
+}
+
+// provenanceFooter returns a footer recording how the changes were produced - the frizbee library and action
+// versions, along with the non-secret inputs of the action
+func provenanceFooter() string {
+	libraryVersion, actionVersion := unknownVersion, unknownVersion
+	if info, ok := debug.ReadBuildInfo(); ok {
+		actionVersion = info.Main.Version
+		for _, dep := range info.Deps {
+			if dep.Path == frizbeeModule {
+				libraryVersion = dep.Version
+			}
+		}
+	}
+	// The ref of the action being run is more meaningful than the version of a binary built from source
+	if ref := os.Getenv("GITHUB_ACTION_REF"); ref != "" {
+		actionVersion = ref
+	}
+
+	var b strings.Builder
+	b.WriteString("Generated by frizbee-action\n")
+	fmt.Fprintf(&b, "frizbee-version: %s\n", libraryVersion)
+	fmt.Fprintf(&b, "frizbee-action-version: %s\n", actionVersion)
+	for _, input := range provenanceInputs() {
+		fmt.Fprintf(&b, "%s\n", input)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// provenanceInputs returns the sorted `name: value` pairs of the non-empty inputs of the action which are recorded in
+// the provenance footer
+func provenanceInputs() []string {
+	var inputs []string
+	for _, name := range provenanceInputNames {
+		value := strings.TrimSpace(os.Getenv("INPUT_" + strings.ToUpper(name)))
+		if value == "" || strings.ContainsAny(value, "\r\n") {
+			continue
+		}
+		inputs = append(inputs, fmt.Sprintf("%s: %s", name, value))
+	}
+	sort.Strings(inputs)
+	return inputs
+}
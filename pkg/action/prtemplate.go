@@ -0,0 +1,69 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// pullRequestTemplateSummary is the hidden comment of the pull request template replaced with the summary of frizbee
+const pullRequestTemplateSummary = "<!-- frizbee-summary -->"
+
+var (
+	// pullRequestTemplateFiles are the locations of the pull request template, in the order GitHub looks them up
+	pullRequestTemplateFiles = []string{
+		".github/pull_request_template.md", ".github/PULL_REQUEST_TEMPLATE.md",
+		"pull_request_template.md", "PULL_REQUEST_TEMPLATE.md",
+		"docs/pull_request_template.md", "docs/PULL_REQUEST_TEMPLATE.md",
+	}
+	// headingRegex matches a Markdown heading line
+	headingRegex = regexp.MustCompile(`(?m)^#{1,6}\s.*$`)
+)
+
+// readPullRequestTemplate returns the pull request template of the repository, or an empty string if it has none
+func readPullRequestTemplate() (string, error) {
+	for _, path := range pullRequestTemplateFiles {
+		content, err := os.ReadFile(path) // nolint:gosec
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		return string(content), nil
+	}
+	return "", nil
+}
+
+// withPullRequestTemplate fills the pull request template of the repository with the summary of frizbee, so the pull
+// request passes the bots rejecting the ones missing the template. The summary replaces the <!-- frizbee-summary -->
+// comment of the template if any, or goes under its first heading, or before it if it has no heading
+func withPullRequestTemplate(summary string) (string, error) {
+	tmpl, err := readPullRequestTemplate()
+	if err != nil || strings.TrimSpace(tmpl) == "" {
+		return summary, err
+	}
+	if strings.Contains(tmpl, pullRequestTemplateSummary) {
+		return strings.Replace(tmpl, pullRequestTemplateSummary, summary, 1), nil
+	}
+	if loc := headingRegex.FindStringIndex(tmpl); loc != nil {
+		return tmpl[:loc[1]] + "\n\n" + summary + tmpl[loc[1]:], nil
+	}
+	return summary + "\n\n" + tmpl, nil
+}
@@ -0,0 +1,645 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+const (
+	// BranchSuffixRunID suffixes the branch of the pull request with the ID of the workflow run
+	BranchSuffixRunID = "run-id"
+	// BranchSuffixContentHash suffixes the branch of the pull request with the hash of the pins
+	BranchSuffixContentHash = "content-hash"
+	// UpdateBranchMerge merges the base branch into the branch of the pull request when it's behind
+	UpdateBranchMerge = "merge"
+	// UpdateBranchRebase rebases the branch of the pull request on the base branch when it's behind
+	UpdateBranchRebase = "rebase"
+	// branchHashLength is the number of hexadecimal digits of the hash of the pins in the name of the branch
+	branchHashLength = 12
+)
+
+// pushPullRequest pushes the commits to the branch of the pull request and opens it, or updates it if it is already
+// open
+func (fa *FrizbeeAction) pushPullRequest(ctx context.Context, pinned []Finding, commits []pull_request.Commit) error {
+	var err error
+	fa.branch, err = fa.branchName(pinned)
+	if err != nil {
+		return err
+	}
+	// The branch named after the hash of the pins already has the same changes if it exists, i.e. pushed by a
+	// concurrent or previous run, so it's left as is
+	pushed := false
+	switch {
+	case fa.BranchSuffix != BranchSuffixContentHash:
+	case fa.WriteMode == WriteModeRemote:
+		_, resp, err := fa.Client.Git.GetRef(ctx, fa.RepoOwner, fa.RepoName, "heads/"+fa.branch)
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			return fmt.Errorf("failed to get branch %s: %w", fa.branch, err)
+		}
+		pushed = err == nil
+	default:
+		if pushed, err = pull_request.RemoteBranchExists(fa.GitRemote, fa.branch); err != nil {
+			return err
+		}
+	}
+	switch {
+	case pushed:
+		log.Printf("Branch %s already has the changes, not pushing them again", fa.branch)
+	case fa.WriteMode == WriteModeRemote:
+		err = fa.pushRemoteCommits(ctx, commits, fa.branch, true)
+	case fa.SignedCommits:
+		err = fa.pushSignedCommits(ctx, commits, fa.branch, true)
+	default:
+		err = fa.pushWithFallback(ctx, commits, fa.branch, true, func() error {
+			return pull_request.CommitSeriesAndPush(commits, fa.GitRemote, fa.branch, fa.ForcePush)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to commit and push the changes: %w", err)
+	}
+	// Open the PR from the fork if the branch was pushed to a remote of another owner
+	headOwner, err := fa.headOwner()
+	if err != nil {
+		return err
+	}
+	// TODO: the default action token does not have permissions to open PRs against workflows in
+	// TODO: '.github/workflows/'. We need to use a PAT or something else to fix this
+	title, body, err := fa.pullRequestText(ctx, pinned)
+	if err != nil {
+		return err
+	}
+	marker := fa.pullRequestMarker(pinned)
+	body = fa.withProvenance(fa.withChecklist(body, pinned)) + "\n\n" + marker.comment()
+	fa.markers = append(fa.markers, marker)
+	if err := fa.createPullRequest(ctx, title, body, headOwner); err != nil {
+		return err
+	}
+	if err := fa.updatePullRequestBranch(ctx, headOwner); err != nil {
+		return err
+	}
+	if err := fa.labelPullRequest(ctx); err != nil {
+		return err
+	}
+	if err := fa.requestReviews(ctx, fa.templateData(pinned).ModifiedFiles); err != nil {
+		return err
+	}
+	return fa.trackPullRequest(ctx)
+}
+
+// createPullRequest opens the pull request from the pushed branch against the base branch, or updates the title and
+// body of the open pull request from the branch if any, as the branch was already pushed with the new commits.
+// The body must end with the marker of the pull request. The headOwner is the owner of the fork the branch was pushed
+// to, or empty if the branch was pushed to the same repository
+func (fa *FrizbeeAction) createPullRequest(ctx context.Context, title, body, headOwner string) error {
+	head := fa.branch
+	if headOwner != "" {
+		head = headOwner + ":" + head
+	}
+	existing, err := fa.openPullRequest(ctx, headOwner)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		pr, _, err := fa.Client.PullRequests.Edit(ctx, fa.RepoOwner, fa.RepoName, existing.GetNumber(),
+			&github.PullRequest{Title: github.String(title), Body: github.String(body)})
+		if err != nil {
+			return fmt.Errorf("failed to update pull request #%d: %w", existing.GetNumber(), err)
+		}
+		fa.pullRequest = pr
+		fa.pullRequests = append(fa.pullRequests, pr)
+		log.Printf("Updated pull request #%d: %s", pr.GetNumber(), pr.GetHTMLURL())
+		return nil
+	}
+	pr, _, err := fa.Client.PullRequests.Create(ctx, fa.RepoOwner, fa.RepoName, &github.NewPullRequest{
+		Title: github.String(title),
+		Body:  github.String(body),
+		Head:  github.String(head),
+		Base:  github.String(fa.BaseBranch),
+		Draft: github.Bool(fa.PRDraft),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create the pull request: %w", err)
+	}
+	fa.pullRequest = pr
+	fa.pullRequests = append(fa.pullRequests, pr)
+	log.Printf("Opened pull request #%d: %s", pr.GetNumber(), pr.GetHTMLURL())
+	return nil
+}
+
+// closeSupersededPullRequests closes the other open pull requests opened by frizbee against the base branch, as the
+// pull requests of the run pin the references afresh, deleting their branches if they are in the repository
+func (fa *FrizbeeAction) closeSupersededPullRequests(ctx context.Context) error {
+	current := make(map[int]bool, len(fa.pullRequests))
+	branches := make(map[string]bool, len(fa.pullRequests))
+	var numbers []string
+	for _, pr := range fa.pullRequests {
+		current[pr.GetNumber()] = true
+		branches[pr.GetHead().GetRef()] = true
+		numbers = append(numbers, fmt.Sprintf("#%d", pr.GetNumber()))
+	}
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		Base:        fa.BaseBranch,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var superseded []*github.PullRequest
+	for {
+		prs, resp, err := fa.Client.PullRequests.List(ctx, fa.RepoOwner, fa.RepoName, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list the open pull requests: %w", err)
+		}
+		for _, pr := range prs {
+			if current[pr.GetNumber()] {
+				continue
+			}
+			marker, _ := parsePullRequestMarker(pr.GetBody())
+			if slices.ContainsFunc(fa.markers, func(m PullRequestMarker) bool { return m.supersedes(marker) }) {
+				superseded = append(superseded, pr)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for _, pr := range superseded {
+		number := pr.GetNumber()
+		comment := fmt.Sprintf("Superseded by %s.", strings.Join(numbers, ", "))
+		_, _, err := fa.Client.Issues.CreateComment(ctx, fa.RepoOwner, fa.RepoName, number,
+			&github.IssueComment{Body: github.String(comment)})
+		if err != nil {
+			return fmt.Errorf("failed to comment on pull request #%d: %w", number, err)
+		}
+		_, _, err = fa.Client.PullRequests.Edit(ctx, fa.RepoOwner, fa.RepoName, number,
+			&github.PullRequest{State: github.String("closed")})
+		if err != nil {
+			return fmt.Errorf("failed to close pull request #%d: %w", number, err)
+		}
+		log.Printf("Closed pull request #%d, superseded by %s", number, strings.Join(numbers, ", "))
+
+		// Only delete the branches of the repository, not the ones of forks
+		branch := pr.GetHead().GetRef()
+		if pr.GetHead().GetRepo().GetFullName() != fa.RepoOwner+"/"+fa.RepoName || branches[branch] {
+			continue
+		}
+		if _, err := fa.Client.Git.DeleteRef(ctx, fa.RepoOwner, fa.RepoName, "heads/"+branch); err != nil {
+			log.Printf("Warning: failed to delete branch %s: %v", branch, err)
+		}
+	}
+	return nil
+}
+
+// deleteStaleBranches deletes the branches of the repository starting with the StaleBranchPrefix whose pull requests
+// opened by frizbee were all merged or closed, so the branches of the past runs don't accumulate. The branches
+// without any pull request are kept, as they may have just been pushed by a concurrent run
+func (fa *FrizbeeAction) deleteStaleBranches(ctx context.Context) error {
+	refs, _, err := fa.Client.Git.ListMatchingRefs(ctx, fa.RepoOwner, fa.RepoName,
+		&github.ReferenceListOptions{Ref: "heads/" + fa.StaleBranchPrefix})
+	if err != nil {
+		return fmt.Errorf("failed to list the branches starting with %s: %w", fa.StaleBranchPrefix, err)
+	}
+	for _, ref := range refs {
+		branch := strings.TrimPrefix(ref.GetRef(), "refs/heads/")
+		if branch == fa.branch || slices.ContainsFunc(fa.pullRequests, func(pr *github.PullRequest) bool {
+			return pr.GetHead().GetRef() == branch
+		}) {
+			continue
+		}
+		prs, _, err := fa.Client.PullRequests.List(ctx, fa.RepoOwner, fa.RepoName, &github.PullRequestListOptions{
+			State:       "all",
+			Head:        fa.RepoOwner + ":" + branch,
+			ListOptions: github.ListOptions{PerPage: 100},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list the pull requests of branch %s: %w", branch, err)
+		}
+		stale := len(prs) > 0
+		for _, pr := range prs {
+			if pr.GetState() == "open" || !isFrizbeePullRequest(pr) {
+				stale = false
+			}
+		}
+		if !stale {
+			continue
+		}
+		if _, err := fa.Client.Git.DeleteRef(ctx, fa.RepoOwner, fa.RepoName, "heads/"+branch); err != nil {
+			log.Printf("Warning: failed to delete branch %s: %v", branch, err)
+			continue
+		}
+		log.Printf("Deleted stale branch %s", branch)
+	}
+	return nil
+}
+
+// updatePullRequestBranchMutation brings the branch of the pull request up to date with its base
+const updatePullRequestBranchMutation = `mutation($input: UpdatePullRequestBranchInput!) {
+  updatePullRequestBranch(input: $input) { pullRequest { headRefOid } }
+}`
+
+// updatePullRequestBranch merges the base branch into the branch of the pull request, or rebases it on the base
+// branch, if the pull request is behind it, i.e. for the branch protections requiring the branches to be up to date
+// before merging. The pushed commits start from the checked out commit, so the branch falls behind as soon as the base
+// branch moves
+func (fa *FrizbeeAction) updatePullRequestBranch(ctx context.Context, headOwner string) error {
+	if fa.UpdateBranch == "" {
+		return nil
+	}
+	head := fa.branch
+	if headOwner != "" {
+		head = headOwner + ":" + head
+	}
+	comparison, _, err := fa.Client.Repositories.CompareCommits(ctx, fa.RepoOwner, fa.RepoName, fa.BaseBranch, head,
+		nil)
+	if err != nil {
+		return fmt.Errorf("failed to compare %s with %s: %w", head, fa.BaseBranch, err)
+	}
+	if comparison.GetBehindBy() == 0 {
+		return nil
+	}
+
+	number := fa.pullRequest.GetNumber()
+	log.Printf("Pull request #%d is %d commits behind %s, updating it with %s", number, comparison.GetBehindBy(),
+		fa.BaseBranch, fa.UpdateBranch)
+	var data struct{}
+	err = fa.graphQL(ctx, updatePullRequestBranchMutation, map[string]any{"input": map[string]any{
+		"pullRequestId":   fa.pullRequest.GetNodeID(),
+		"expectedHeadOid": fa.pullRequest.GetHead().GetSHA(),
+		"updateMethod":    strings.ToUpper(fa.UpdateBranch),
+	}}, &data)
+	if err != nil {
+		return fmt.Errorf("failed to update the branch of pull request #%d: %w", number, err)
+	}
+	return nil
+}
+
+// resolveBaseBranch sets the base branch of the pull request to the default branch of the repository if it is not
+// known from the event payload, falling back to DefaultBaseBranch if the repository can't be read
+func (fa *FrizbeeAction) resolveBaseBranch(ctx context.Context) {
+	if fa.BaseBranch != "" {
+		return
+	}
+	repo, _, err := fa.Client.Repositories.Get(ctx, fa.RepoOwner, fa.RepoName)
+	if err != nil || repo.GetDefaultBranch() == "" {
+		log.Printf("Warning: failed to get the default branch of %s/%s, using %s: %v", fa.RepoOwner, fa.RepoName,
+			pull_request.DefaultBaseBranch, err)
+		fa.BaseBranch = pull_request.DefaultBaseBranch
+		return
+	}
+	fa.BaseBranch = repo.GetDefaultBranch()
+}
+
+// openPullRequest returns the open pull request from the branch against the base branch, or nil if there is none
+func (fa *FrizbeeAction) openPullRequest(ctx context.Context, headOwner string) (*github.PullRequest, error) {
+	if headOwner == "" {
+		headOwner = fa.RepoOwner
+	}
+	prs, _, err := fa.Client.PullRequests.List(ctx, fa.RepoOwner, fa.RepoName, &github.PullRequestListOptions{
+		State: "open",
+		Head:  headOwner + ":" + fa.branch,
+		Base:  fa.BaseBranch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the open pull requests: %w", err)
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return prs[0], nil
+}
+
+// labelPullRequest adds the labels to the pull request, keeping the labels it already has
+func (fa *FrizbeeAction) labelPullRequest(ctx context.Context) error {
+	if len(fa.PRLabels) == 0 {
+		return nil
+	}
+	_, _, err := fa.Client.Issues.AddLabelsToIssue(ctx, fa.RepoOwner, fa.RepoName, fa.pullRequest.GetNumber(), fa.PRLabels)
+	if err != nil {
+		return fmt.Errorf("failed to label pull request #%d: %w", fa.pullRequest.GetNumber(), err)
+	}
+	return nil
+}
+
+// requestReviews requests the reviews of the reviewers and teams on the pull request, along with the code owners of
+// the files if requested, and assigns it to the assignees
+func (fa *FrizbeeAction) requestReviews(ctx context.Context, files []string) error {
+	number := fa.pullRequest.GetNumber()
+	reviewers, teamReviewers := slices.Clone(fa.PRReviewers), slices.Clone(fa.PRTeamReviewers)
+	if fa.CodeownersReviewers {
+		users, teams, err := fa.codeownersReviewers(files)
+		if err != nil {
+			return err
+		}
+		// The author of the pull request can't review it
+		for _, user := range users {
+			if !strings.EqualFold(user, fa.pullRequest.GetUser().GetLogin()) && !slices.Contains(reviewers, user) {
+				reviewers = append(reviewers, user)
+			}
+		}
+		for _, team := range teams {
+			if !slices.Contains(teamReviewers, team) {
+				teamReviewers = append(teamReviewers, team)
+			}
+		}
+	}
+	if len(reviewers) > 0 || len(teamReviewers) > 0 {
+		_, _, err := fa.Client.PullRequests.RequestReviewers(ctx, fa.RepoOwner, fa.RepoName, number,
+			github.ReviewersRequest{Reviewers: reviewers, TeamReviewers: teamReviewers})
+		if err != nil {
+			return fmt.Errorf("failed to request reviewers on pull request #%d: %w", number, err)
+		}
+	}
+	if len(fa.PRAssignees) > 0 {
+		_, _, err := fa.Client.Issues.AddAssignees(ctx, fa.RepoOwner, fa.RepoName, number, fa.PRAssignees)
+		if err != nil {
+			return fmt.Errorf("failed to assign pull request #%d: %w", number, err)
+		}
+	}
+	return nil
+}
+
+// projectURLRegex matches the URL of a project owned by an organization or a user
+var projectURLRegex = regexp.MustCompile(`^https?://[^/]+/(orgs|users)/([^/]+)/projects/(\d+)/?$`)
+
+// addToProjectMutation adds the pull request to a project
+const addToProjectMutation = `mutation($project: ID!, $content: ID!) {
+  addProjectV2ItemById(input: {projectId: $project, contentId: $content}) { item { id } }
+}`
+
+// Project is a project the pull request is added to
+type Project struct {
+	// Owner is the login of the organization or user owning the project
+	Owner string
+	// Organization is true if the project is owned by an organization
+	Organization bool
+	// Number is the number of the project
+	Number int
+}
+
+// ParseProject parses the URL of a project, i.e. https://github.com/orgs/my-org/projects/1
+func ParseProject(url string) (*Project, error) {
+	m := projectURLRegex.FindStringSubmatch(url)
+	if m == nil {
+		return nil, fmt.Errorf("%q is not the URL of a project", url)
+	}
+	number, err := strconv.Atoi(m[3])
+	if err != nil {
+		return nil, fmt.Errorf("%q is not the URL of a project: %w", url, err)
+	}
+	return &Project{Owner: m[2], Organization: m[1] == "orgs", Number: number}, nil
+}
+
+// trackPullRequest sets the milestone of the pull request and adds it to the project, if set
+func (fa *FrizbeeAction) trackPullRequest(ctx context.Context) error {
+	number := fa.pullRequest.GetNumber()
+	if fa.PRMilestone != "" {
+		milestone, err := fa.milestoneNumber(ctx)
+		if err != nil {
+			return err
+		}
+		_, _, err = fa.Client.Issues.Edit(ctx, fa.RepoOwner, fa.RepoName, number,
+			&github.IssueRequest{Milestone: &milestone})
+		if err != nil {
+			return fmt.Errorf("failed to set the milestone of pull request #%d: %w", number, err)
+		}
+	}
+	if fa.PRProject != nil {
+		owner := "user"
+		if fa.PRProject.Organization {
+			owner = "organization"
+		}
+		var project map[string]struct {
+			ProjectV2 struct {
+				ID string `json:"id"`
+			} `json:"projectV2"`
+		}
+		query := fmt.Sprintf(`query($owner: String!, $number: Int!) { %s(login: $owner) { projectV2(number: $number) `+
+			`{ id } } }`, owner)
+		err := fa.graphQL(ctx, query, map[string]any{"owner": fa.PRProject.Owner, "number": fa.PRProject.Number},
+			&project)
+		if err != nil {
+			return fmt.Errorf("failed to get project %d of %s: %w", fa.PRProject.Number, fa.PRProject.Owner, err)
+		}
+		var item map[string]any
+		err = fa.graphQL(ctx, addToProjectMutation, map[string]any{
+			"project": project[owner].ProjectV2.ID,
+			"content": fa.pullRequest.GetNodeID(),
+		}, &item)
+		if err != nil {
+			return fmt.Errorf("failed to add pull request #%d to project %d of %s: %w", number,
+				fa.PRProject.Number, fa.PRProject.Owner, err)
+		}
+	}
+	return nil
+}
+
+// milestoneNumber returns the number of the milestone, which is given either by number or by title
+func (fa *FrizbeeAction) milestoneNumber(ctx context.Context) (int, error) {
+	if number, err := strconv.Atoi(fa.PRMilestone); err == nil {
+		return number, nil
+	}
+	opts := &github.MilestoneListOptions{State: "open", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		milestones, resp, err := fa.Client.Issues.ListMilestones(ctx, fa.RepoOwner, fa.RepoName, opts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list the milestones: %w", err)
+		}
+		for _, m := range milestones {
+			if m.GetTitle() == fa.PRMilestone {
+				return m.GetNumber(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return 0, fmt.Errorf("no open milestone is titled %q", fa.PRMilestone)
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// TemplateData is the data the pull request title, body and branch templates are executed with
+type TemplateData struct {
+	// RunID is the ID of the workflow run
+	RunID string
+	// Date is the date of the run, i.e. 2024-05-01
+	Date string
+	// Category is the category of the pinned references, i.e. actions or images, if the pull requests are split by
+	// category
+	Category string
+	// Directory is the directory of the modified files, if the pull requests are split by directory
+	Directory string
+	// Batch is the number of the batch of the pull request starting from 1, if the pull requests are split in batches
+	Batch int
+	// Batches is the number of batches
+	Batches int
+	// RepoOwner is the owner of the repository
+	RepoOwner string
+	// RepoName is the name of the repository
+	RepoName string
+	// ModifiedFiles is the list of the files modified by frizbee
+	ModifiedFiles []string
+	// PinCount is the number of references pinned, including the pins advanced because their tag moved
+	PinCount int
+	// Pins is the list of the pinned references, each with the files they were found in
+	Pins []FindingGroup
+	// PinTable is a Markdown table of the pinned dependencies, with their version before and after and a link to it
+	PinTable string
+}
+
+// ParseTemplate parses the text/template of the pull request title, body or branch, and executes it once with empty
+// data to fail early on the unknown fields of TemplateData
+func ParseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(io.Discard, TemplateData{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// templateData returns the data of the pull request templates, given the findings which were pinned
+func (fa *FrizbeeAction) templateData(pinned []Finding) TemplateData {
+	var pins []Finding
+	for _, f := range fa.groupedFindings(append(slices.Clone(pinned), fa.refreshed...)) {
+		if f.Pinned != "" {
+			pins = append(pins, f)
+		}
+	}
+	modifiedFiles := fa.modifiedFiles
+	if fa.group.files != nil {
+		modifiedFiles = nil
+		for file := range fa.group.files {
+			modifiedFiles = append(modifiedFiles, filepath.ToSlash(file))
+		}
+		slices.Sort(modifiedFiles)
+	}
+	return TemplateData{
+		RunID:         fa.RunID,
+		Date:          time.Now().UTC().Format(time.DateOnly),
+		Category:      fa.group.category.category,
+		Directory:     fa.group.directory,
+		Batch:         fa.group.batch,
+		Batches:       fa.group.batches,
+		RepoOwner:     fa.RepoOwner,
+		RepoName:      fa.RepoName,
+		ModifiedFiles: modifiedFiles,
+		PinCount:      len(pins),
+		Pins:          groupFindings(pins),
+		PinTable:      pinTable(groupFindings(pins), nil),
+	}
+}
+
+// pullRequestText returns the title and body of the pull request, from the templates if set, the default body filling
+// the pull request template of the repository if requested. The table of the pins links to the release notes of the
+// actions
+func (fa *FrizbeeAction) pullRequestText(ctx context.Context, pinned []Finding) (string, string, error) {
+	data := fa.templateData(pinned)
+	data.PinTable = pinTable(data.Pins, fa.releaseNotes(ctx, data.Pins))
+	title, body := pull_request.DefaultTitle, pull_request.DefaultBody
+	switch {
+	case data.Category != "":
+		title = fmt.Sprintf(pull_request.DefaultCategoryTitle, data.Category)
+		body = fmt.Sprintf(pull_request.DefaultCategoryBody, data.Category)
+	case data.Directory != "":
+		title = fmt.Sprintf(pull_request.DefaultDirectoryTitle, data.Directory)
+		body = fmt.Sprintf(pull_request.DefaultDirectoryBody, data.Directory)
+	case data.Batch > 0:
+		title = fmt.Sprintf("%s (%d/%d)", title, data.Batch, data.Batches)
+	}
+	if data.PinTable != "" {
+		body += ":\n\n" + data.PinTable
+	}
+	if fa.UsePRTemplate {
+		var err error
+		if body, err = withPullRequestTemplate(body); err != nil {
+			return "", "", err
+		}
+	}
+	for _, t := range []struct {
+		tmpl *template.Template
+		text *string
+	}{{fa.PRTitleTemplate, &title}, {fa.PRBodyTemplate, &body}} {
+		if t.tmpl == nil {
+			continue
+		}
+		var b strings.Builder
+		if err := t.tmpl.Execute(&b, data); err != nil {
+			return "", "", fmt.Errorf("failed to execute the %s template: %w", t.tmpl.Name(), err)
+		}
+		*t.text = b.String()
+	}
+	// The title is a single line
+	return strings.Join(strings.Fields(title), " "), body, nil
+}
+
+// pinsHash returns a short hash of the pins of the findings, which is the same for the runs pinning the same
+// references in the same files
+func pinsHash(findings []Finding) string {
+	pins := make([]string, 0, len(findings))
+	for _, f := range findings {
+		if f.Pinned != "" {
+			pins = append(pins, fmt.Sprintf("%s:%d:%s:%s", filepath.ToSlash(f.File), f.Line, f.Original, f.Pinned))
+		}
+	}
+	slices.Sort(pins)
+	sum := sha256.Sum256([]byte(strings.Join(pins, "\n")))
+	return hex.EncodeToString(sum[:])[:branchHashLength]
+}
+
+// branchName returns the name of the branch the changes are pushed to, from the template if set. The category or
+// directory is appended to it if the pull requests are split
+func (fa *FrizbeeAction) branchName(pinned []Finding) (string, error) {
+	name := pull_request.DefaultBranchName
+	if fa.BranchTemplate != nil {
+		var b strings.Builder
+		if err := fa.BranchTemplate.Execute(&b, fa.templateData(pinned)); err != nil {
+			return "", fmt.Errorf("failed to execute the branch template: %w", err)
+		}
+		name = strings.TrimSpace(b.String())
+	}
+	if suffix := fa.group.suffix(); suffix != "" {
+		name += "-" + suffix
+	}
+	switch fa.BranchSuffix {
+	case BranchSuffixRunID:
+		name += "-" + fa.RunID
+	case BranchSuffixContentHash:
+		name += "-" + pinsHash(fa.groupedFindings(append(slices.Clone(pinned), fa.refreshed...)))
+	}
+	if err := pull_request.CheckBranchName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,101 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+// checkoutRef switches to a copy of the files of the commit of the Ref to scan it instead of the working tree, which
+// is left untouched. The paths of the outputs are made absolute first, so they are still written relative to the
+// repository. The changes can't be committed unless the Ref is the checked out commit. It returns a function switching
+// back to the repository and removing the copy
+func (fa *FrizbeeAction) checkoutRef() (func(), error) {
+	sha, err := pull_request.ResolveRef(fa.Ref, pull_request.DefaultRemote)
+	if err != nil {
+		return nil, err
+	}
+	fa.HeadSHA = sha
+	head, err := pull_request.HeadSHA()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the checked out commit: %w", err)
+	}
+	if sha == head {
+		log.Printf("Scanning %s, the checked out commit %s", fa.Ref, sha)
+		return func() {}, nil
+	}
+	if fa.OpenPR && !fa.TransformOnly {
+		return nil, fmt.Errorf("can't open a pull request for %s, it is not the checked out commit", fa.Ref)
+	}
+	if fa.TransformOnly && fa.OutputDir == "" {
+		return nil, fmt.Errorf("can't write the files of %s in place, it is not the checked out commit, "+
+			"set an output directory", fa.Ref)
+	}
+
+	if err := fa.absoluteOutputs(); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "frizbee-ref-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkout directory: %w", err)
+	}
+	if err := pull_request.ExtractCommit(dir, sha); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return nil, err
+	}
+	log.Printf("Scanning %s at commit %s", fa.Ref, sha)
+	return func() {
+		if err := os.Chdir(wd); err != nil {
+			log.Printf("Warning: failed to switch back to %s: %v", wd, err)
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Warning: failed to remove the checkout of %s: %v", fa.Ref, err)
+		}
+	}, nil
+}
+
+// absoluteOutputs makes the paths of the outputs absolute, so they are still written relative to the working directory
+// once switched to another directory to scan
+func (fa *FrizbeeAction) absoluteOutputs() error {
+	outputs := []*string{&fa.ResultArtifactDir, &fa.OnlyPathsFile, &fa.ResultCallbackFile, &fa.OutputDir}
+	if fa.WriteBaseline {
+		outputs = append(outputs, &fa.BaselineFile)
+	}
+	for _, path := range outputs {
+		if *path == "" {
+			continue
+		}
+		var err error
+		if *path, err = filepath.Abs(*path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
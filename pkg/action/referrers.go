@@ -0,0 +1,161 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const (
+	// referenceTypeAnnotation is the annotation buildx sets on the attestation manifests of an image index
+	referenceTypeAnnotation = "vnd.docker.reference.type"
+	// attestationManifest is the value of the referenceTypeAnnotation of an attestation manifest
+	attestationManifest = "attestation-manifest"
+)
+
+// imageConfigMediaTypes are the media types of the config of a runnable image manifest
+var imageConfigMediaTypes = []string{
+	"application/vnd.oci.image.config.v1+json",
+	"application/vnd.docker.container.image.v1+json",
+}
+
+// artifactLayerMediaTypes are the prefixes of the media types of the layers of signatures and attestations that are
+// pushed with an image config, as cosign does
+var artifactLayerMediaTypes = []string{
+	"application/vnd.dev.cosign.",
+	"application/vnd.in-toto+json",
+	"application/vnd.dsse.envelope.",
+}
+
+// manifestDescriptor is the subset of an OCI descriptor used to tell images and artifacts apart
+type manifestDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest is the subset of an OCI image manifest or index used to tell images and artifacts apart
+type ociManifest struct {
+	MediaType    string               `json:"mediaType"`
+	ArtifactType string               `json:"artifactType,omitempty"`
+	Config       *manifestDescriptor  `json:"config,omitempty"`
+	Layers       []manifestDescriptor `json:"layers,omitempty"`
+	Subject      *manifestDescriptor  `json:"subject,omitempty"`
+	Manifests    []manifestDescriptor `json:"manifests,omitempty"`
+}
+
+// withoutArtifacts reverts the lines of the file whose image references were pinned to the digest of a referrer
+// artifact, e.g. a cosign signature, an SBOM or an attestation, rather than to a runnable image. A tag pointing to an
+// image index is fine as long as the index lists at least one image manifest besides its attestations. An error is
+// returned instead if the action is set to fail on errors. It returns the content without the artifact pins
+func (fa *FrizbeeAction) withoutArtifacts(ctx context.Context, path, original, content string) (string, error) {
+	originalLines := strings.Split(original, "\n")
+	lines := strings.Split(content, "\n")
+	if len(originalLines) != len(lines) {
+		return content, nil
+	}
+	if fa.artifacts == nil {
+		fa.artifacts = make(map[string]string)
+	}
+
+	for i, line := range lines {
+		if line == originalLines[i] {
+			continue
+		}
+		for _, ref := range pinnedImageRegex.FindAllString(line, -1) {
+			ref = strings.TrimPrefix(ref, "docker://")
+			reason, ok := fa.artifacts[ref]
+			if !ok {
+				var err error
+				reason, err = artifactReason(ctx, ref)
+				if err != nil {
+					// The digest was just resolved, so leave the reachability to the other checks
+					log.Printf("Warning: failed to get the manifest of %s: %v", ref, err)
+				}
+				fa.artifacts[ref] = reason
+			}
+			if reason == "" {
+				continue
+			}
+			if fa.FailOnError {
+				return "", fmt.Errorf("%s in %s is not a runnable image: %s", ref, path, reason)
+			}
+			log.Printf("Warning: not pinning line %d of %s, %s is not a runnable image: %s", i+1, path, ref, reason)
+			lines[i] = originalLines[i]
+			break
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// artifactReason fetches the manifest of the image reference pinned to a digest and returns why it is a referrer
+// artifact rather than a runnable image, or an empty string if it is an image
+func artifactReason(ctx context.Context, ref string) (string, error) {
+	digest, err := name.NewDigest(ref)
+	if err != nil {
+		return "", err
+	}
+	desc, err := remote.Get(digest, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return "", err
+	}
+	var m ociManifest
+	if err := json.Unmarshal(desc.Manifest, &m); err != nil {
+		return "", fmt.Errorf("failed to parse the manifest: %w", err)
+	}
+	return m.artifactReason(), nil
+}
+
+// artifactReason returns why the manifest is a referrer artifact rather than a runnable image, or an empty string if
+// it is an image
+func (m ociManifest) artifactReason() string {
+	switch {
+	case m.ArtifactType != "":
+		return fmt.Sprintf("it is an artifact of type %s", m.ArtifactType)
+	case m.Subject != nil:
+		return fmt.Sprintf("it is a referrer of %s", m.Subject.Digest)
+	case m.Config == nil && len(m.Manifests) > 0:
+		// An index, which is runnable if it lists a single image manifest
+		for _, d := range m.Manifests {
+			if d.ArtifactType == "" && d.Annotations[referenceTypeAnnotation] != attestationManifest {
+				return ""
+			}
+		}
+		return "it is an index of referrer artifacts"
+	case m.Config == nil:
+		return ""
+	case !slices.Contains(imageConfigMediaTypes, m.Config.MediaType):
+		return fmt.Sprintf("its config is of type %s", m.Config.MediaType)
+	}
+	for _, l := range m.Layers {
+		for _, prefix := range artifactLayerMediaTypes {
+			if strings.HasPrefix(l.MediaType, prefix) {
+				return fmt.Sprintf("it has a layer of type %s", l.MediaType)
+			}
+		}
+	}
+	return ""
+}
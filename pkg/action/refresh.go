@@ -0,0 +1,73 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+)
+
+var (
+	// pinnedActionRegex matches actions pinned to a commit SHA with their tag in a comment, capturing the action,
+	// the SHA and the tag
+	pinnedActionRegex = regexp.MustCompile(
+		`^\s*(?:-\s+)?uses:\s*([^\s@]+)@([0-9a-f]{40})\s+#\s*(?:frizbee:\s*)?([^\s#]+)`)
+	// taggedDigestRegex matches images pinned to a digest with their tag in a comment, capturing the image, the
+	// digest and the tag
+	taggedDigestRegex = regexp.MustCompile(`([^\s"'=@]+)@(sha256:[0-9a-f]{64})["']?\s+#\s*(?:frizbee:\s*)?([^\s#]+)`)
+	// inlineDigestRegex matches images pinned to a digest while keeping their tag, capturing the image with its
+	// tag and the digest
+	inlineDigestRegex = regexp.MustCompile(`([^\s"'=@]+:[^\s"'=@/:]+)@(sha256:[0-9a-f]{64})`)
+)
+
+// refreshPins advances the pinned references of the content whose tag now points to another commit SHA or digest,
+// returning the refreshed content along with the 1-based numbers of the refreshed lines. References which fail to
+// resolve are left untouched
+func (fa *FrizbeeAction) refreshPins(ctx context.Context, path, content string) (string, []int) {
+	var refreshed []int
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		var pinned, current string
+		if m := pinnedActionRegex.FindStringSubmatch(line); m != nil {
+			ref, err := fa.ActionsReplacer.ParseString(ctx, m[1]+"@"+m[3])
+			if err != nil {
+				continue
+			}
+			pinned, current = m[2], ref.Ref
+		} else if m := taggedDigestRegex.FindStringSubmatch(line); m != nil {
+			ref, err := fa.ImagesReplacer.ParseString(ctx, m[1]+":"+m[3])
+			if err != nil {
+				continue
+			}
+			pinned, current = m[2], ref.Ref
+		} else if m := inlineDigestRegex.FindStringSubmatch(line); m != nil {
+			ref, err := fa.ImagesReplacer.ParseString(ctx, m[1])
+			if err != nil {
+				continue
+			}
+			pinned, current = m[2], ref.Ref
+		}
+		if pinned == "" || current == "" || current == pinned {
+			continue
+		}
+		log.Printf("Advancing the pin of line %d of %s from %s to %s, its tag moved", i+1, path, pinned, current)
+		lines[i] = strings.Replace(line, pinned, current, 1)
+		refreshed = append(refreshed, i+1)
+	}
+	return strings.Join(lines, "\n"), refreshed
+}
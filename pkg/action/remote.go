@@ -0,0 +1,270 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/v60/github"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+const (
+	// WriteModeGit commits the changes to the checked out repository with git and pushes them
+	WriteModeGit = "git"
+	// WriteModeRemote reads the repository and creates the commits with the GitHub API, without a checkout
+	WriteModeRemote = "remote"
+)
+
+// downloadSnapshot downloads the tarball of the commit to scan, i.e. the Ref, the head commit of the event or the
+// default branch, and switches to the directory it is extracted to, so the repository can be scanned without being
+// checked out. The paths of the outputs are made absolute first, so they are still written relative to the working
+// directory. It returns a function switching back to the working directory and removing the snapshot
+func (fa *FrizbeeAction) downloadSnapshot(ctx context.Context) (func(), error) {
+	ref := fa.Ref
+	if ref == "" {
+		ref = fa.HeadSHA
+	}
+	if ref == "" {
+		fa.resolveBaseBranch(ctx)
+		ref = fa.BaseBranch
+	}
+	sha, _, err := fa.Client.Repositories.GetCommitSHA1(ctx, fa.RepoOwner, fa.RepoName, ref, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	fa.snapshotSHA = sha
+	fa.HeadSHA = sha
+
+	if err := fa.absoluteOutputs(); err != nil {
+		return nil, err
+	}
+
+	link, _, err := fa.Client.Repositories.GetArchiveLink(ctx, fa.RepoOwner, fa.RepoName, github.Tarball,
+		&github.RepositoryContentGetOptions{Ref: sha}, 3)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the tarball of %s: %w", sha, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// The link embeds a short-lived token, so it's left out of the errors
+	resp, err := fa.Client.Client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download the tarball of %s: %w", sha, errors.Unwrap(err))
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download the tarball of %s: %s", sha, resp.Status)
+	}
+
+	dir, err := os.MkdirTemp("", "frizbee-snapshot-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	if err := extractTarball(resp.Body, dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to extract the tarball of %s: %w", sha, err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return nil, err
+	}
+	log.Printf("Scanning %s at commit %s without a checkout", ref, sha)
+	return func() {
+		if err := os.Chdir(wd); err != nil {
+			log.Printf("Warning: failed to switch back to %s: %v", wd, err)
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Warning: failed to remove the snapshot of %s: %v", sha, err)
+		}
+	}, nil
+}
+
+// extractTarball extracts the gzipped tarball of a repository into the directory, without the top-level directory
+// GitHub puts the files in. The entries escaping the directory are rejected
+func extractTarball(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, name, found := strings.Cut(hdr.Name, "/")
+		if !found || name == "" {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return fmt.Errorf("the entry %s is outside of the repository", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil { // nolint:gosec
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil { // nolint:gosec
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm()) // nolint:gosec
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil { // nolint:gosec
+				_ = f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil { // nolint:gosec
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pushRemoteCommits creates the commits on top of the scanned commit with the Git Data API, creating the blobs and
+// trees of their files, and points the branch to the last one. For the branch of the pull request, the commits are
+// added on top of its existing head, see rebaseOnBranch, otherwise the branch must not have moved since the scan. The
+// last commit includes the current content of all the changed files, unless it has files of its own
+func (fa *FrizbeeAction) pushRemoteCommits(ctx context.Context, commits []pull_request.Commit, branch string,
+	pullRequest bool) error {
+	changes := make([]commitFiles, len(commits))
+	for i, c := range commits {
+		changes[i].files = c.Files
+		if i == len(commits)-1 && c.Files == nil {
+			changes[i].files = make(map[string]string, len(fa.changes))
+			for _, change := range fa.changes {
+				content, err := os.ReadFile(change.Path) // nolint:gosec
+				if err != nil {
+					return fmt.Errorf("failed to read file %s: %w", change.Path, err)
+				}
+				changes[i].files[change.Path] = string(content)
+			}
+		}
+	}
+	head := fa.snapshotSHA
+	if pullRequest {
+		var err error
+		if head, err = fa.rebaseOnBranch(ctx, branch, fa.snapshotSHA, changes); err != nil {
+			return err
+		}
+	}
+
+	parent, _, err := fa.Client.Git.GetCommit(ctx, fa.RepoOwner, fa.RepoName, head)
+	if err != nil {
+		return fmt.Errorf("failed to get commit %s: %w", head, err)
+	}
+	for i, c := range commits {
+		if len(changes[i].files) == 0 {
+			log.Printf("Branch %s already has the changes of %q, not committing them again", branch, c.Message)
+			continue
+		}
+		entries := make([]*github.TreeEntry, 0, len(changes[i].files))
+		for path, content := range changes[i].files {
+			blob, _, err := fa.Client.Git.CreateBlob(ctx, fa.RepoOwner, fa.RepoName, &github.Blob{
+				Content:  github.String(content),
+				Encoding: github.String("utf-8"),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create the blob of %s: %w", path, err)
+			}
+			mode := "100644"
+			if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0111 != 0 {
+				mode = "100755"
+			}
+			entries = append(entries, &github.TreeEntry{
+				Path: github.String(filepath.ToSlash(path)),
+				Mode: github.String(mode),
+				Type: github.String("blob"),
+				SHA:  blob.SHA,
+			})
+		}
+		tree, _, err := fa.Client.Git.CreateTree(ctx, fa.RepoOwner, fa.RepoName, parent.GetTree().GetSHA(), entries)
+		if err != nil {
+			return fmt.Errorf("failed to create the tree of the commit: %w", err)
+		}
+		parent, _, err = fa.Client.Git.CreateCommit(ctx, fa.RepoOwner, fa.RepoName, &github.Commit{
+			Message: github.String(pull_request.FullMessage(c.Message)),
+			Tree:    tree,
+			Parents: []*github.Commit{{SHA: parent.SHA}},
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create the commit: %w", err)
+		}
+		log.Printf("Created commit %s for %s", parent.GetSHA(), branch)
+	}
+	if parent.GetSHA() == head {
+		return nil
+	}
+
+	ref := &github.Reference{Ref: github.String("refs/heads/" + branch), Object: &github.GitObject{SHA: parent.SHA}}
+	if _, _, err := fa.Client.Git.UpdateRef(ctx, fa.RepoOwner, fa.RepoName, ref, false); err != nil {
+		return fmt.Errorf("failed to update branch %s, it may have moved since the scan: %w", branch, err)
+	}
+	return nil
+}
+
+// pushWithFallback pushes the commits to the branch with the given git push function. If the push is rejected by the
+// rules of the repository, i.e. a ruleset requiring signed commits, the commits are created with the Git Data API on
+// top of the checked out commit instead if the fallback is enabled, see pushRemoteCommits
+func (fa *FrizbeeAction) pushWithFallback(ctx context.Context, commits []pull_request.Commit, branch string,
+	pullRequest bool, push func() error) error {
+	base, err := pull_request.HeadSHA()
+	if err != nil {
+		return fmt.Errorf("failed to get the checked out commit: %w", err)
+	}
+	err = push()
+	if !errors.Is(err, pull_request.ErrPushRejected) {
+		return err
+	}
+	if !fa.PushFallback || fa.GitRemote != pull_request.DefaultRemote {
+		return fmt.Errorf("%w. Set push_fallback to true to create the commits with the GitHub API when the push is "+
+			"rejected, or signed_commits to always create them with it", err)
+	}
+	log.Printf("Warning: %v, creating the commits with the GitHub API instead", err)
+	fa.snapshotSHA = base
+	return fa.pushRemoteCommits(ctx, commits, branch, pullRequest)
+}
@@ -0,0 +1,278 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+)
+
+const (
+	// reportFileName is the name of the JSON report in the result artifact directory
+	reportFileName = "report.json"
+	// sarifFileName is the name of the SARIF report in the result artifact directory
+	sarifFileName = "results.sarif"
+	// patchFileName is the name of the patch with all the changes in the result artifact directory
+	patchFileName = "changes.patch"
+	// diffFileName is the name of the diff of all the changes, as logged, in the result artifact directory
+	diffFileName = "changes.diff"
+)
+
+// fileChange is a file modified by frizbee, along with its original and modified content
+type fileChange struct {
+	// Path is the path of the file, relative to the repository root
+	Path     string
+	Original string
+	Modified string
+}
+
+// Report is the JSON report of a run
+type Report struct {
+	// Processed is the list of the files processed by frizbee
+	Processed []string `json:"processed"`
+	// Modified is the list of the files with unpinned references
+	Modified []string `json:"modified"`
+	// Findings is the list of the unpinned references
+	Findings []Finding `json:"findings"`
+	// Skipped is the list of the files abandoned because processing them took too long
+	Skipped []string `json:"skipped"`
+	// Refreshed is the list of the pinned references advanced because their tag moved
+	Refreshed []Finding `json:"refreshed"`
+	// Unresolvable is the list of the references left unpinned because they don't exist or can't be accessed
+	Unresolvable []string `json:"unresolvable"`
+	// Coverage is the number of references of each file by status
+	Coverage []FileCoverage `json:"coverage"`
+	// Total is the number of references of all the files by status
+	Total FileCoverage `json:"total"`
+	// Groups is the list of the findings grouped by reference, only set if the findings are deduplicated
+	Groups []FindingGroup `json:"groups,omitempty"`
+}
+
+// report returns the JSON report of the run
+func (fa *FrizbeeAction) report() Report {
+	r := Report{
+		Processed:    fa.processedFiles,
+		Modified:     make([]string, 0, len(fa.changes)),
+		Findings:     fa.findings,
+		Skipped:      fa.skippedFiles,
+		Refreshed:    fa.refreshed,
+		Unresolvable: fa.ResolveErrors.listUnresolvable(),
+	}
+	for _, c := range fa.changes {
+		r.Modified = append(r.Modified, c.Path)
+	}
+	r.Coverage, r.Total = fa.coverage()
+	if r.Processed == nil {
+		r.Processed = []string{}
+	}
+	if r.Findings == nil {
+		r.Findings = []Finding{}
+	}
+	if r.Skipped == nil {
+		r.Skipped = []string{}
+	}
+	if r.Refreshed == nil {
+		r.Refreshed = []Finding{}
+	}
+	if r.Unresolvable == nil {
+		r.Unresolvable = []string{}
+	}
+	if fa.DedupeFindings {
+		r.Groups = groupFindings(fa.findings)
+	}
+	return r
+}
+
+// patch returns a unified diff with the changes to all the modified files, which can be applied with `git apply`. It
+// always has the default context, as git can't apply the hunks without context lines
+func (fa *FrizbeeAction) patch() string {
+	return fa.diff(DefaultDiffContext)
+}
+
+// diff returns a unified diff with the changes to all the modified files, with the given number of context lines
+func (fa *FrizbeeAction) diff(context int) string {
+	var b strings.Builder
+	for _, c := range fa.changes {
+		b.WriteString(unifiedDiff(c.Path, c.Original, c.Modified, context))
+	}
+	return b.String()
+}
+
+// writeResultArtifact writes all the report outputs to the given directory using stable file names, clearing the
+// directory first so no output of a previous run is left behind
+func (fa *FrizbeeAction) writeResultArtifact(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear directory %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	report, err := json.MarshalIndent(fa.report(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	sarif, err := json.MarshalIndent(fa.sarif(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal SARIF report: %w", err)
+	}
+
+	outputs := map[string][]byte{
+		reportFileName: report,
+		sarifFileName:  sarif,
+		patchFileName:  []byte(fa.patch()),
+		diffFileName:   []byte(fa.diff(fa.DiffContext)),
+	}
+	for name, content := range outputs {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	log.Printf("Wrote the results to %s", dir)
+	return nil
+}
+
+// printReport prints the JSON report of the run to the standard output as a single line
+func (fa *FrizbeeAction) printReport() error {
+	report, err := json.Marshal(fa.report())
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", report)
+	return err
+}
+
+// sarifLog is the subset of the SARIF 2.1.0 format used by frizbee
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// sarif returns the findings as a SARIF log
+func (fa *FrizbeeAction) sarif() sarifLog {
+	level := "warning"
+	if fa.FailOnUnpinned {
+		level = "error"
+	}
+	results := make([]sarifResult, 0, len(fa.findings))
+	for _, f := range fa.findings {
+		results = append(results, sarifResult{
+			RuleID:  sarifRuleID(f.Type),
+			Level:   level,
+			Message: sarifMessage{Text: sarifMessageText(f)},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(f.File)},
+					Region:           sarifRegion{StartLine: f.Line},
+				},
+			}},
+		})
+	}
+	return sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "frizbee",
+				InformationURI: "https://github.com/stacklok/frizbee",
+				Rules: []sarifRule{
+					{ID: "unpinned-action", ShortDescription: sarifMessage{Text: "Action is not pinned to a commit SHA"}},
+					{ID: "unpinned-image", ShortDescription: sarifMessage{Text: "Container image is not pinned to a digest"}},
+					{ID: "unpinned-chart", ShortDescription: sarifMessage{Text: "Helm chart dependency is not pinned"}},
+					{ID: "unpinned-orb", ShortDescription: sarifMessage{Text: "CircleCI orb is not pinned to an exact version"}},
+				},
+			}},
+			Results: results,
+		}},
+	}
+}
+
+// sarifRuleID returns the SARIF rule ID for the type of reference
+func sarifRuleID(refType string) string {
+	switch refType {
+	case actions.ReferenceType:
+		return "unpinned-action"
+	case chartReferenceType:
+		return "unpinned-chart"
+	case orbReferenceType:
+		return "unpinned-orb"
+	default:
+		return "unpinned-image"
+	}
+}
+
+// sarifMessageText returns the message of the SARIF result of the finding
+func sarifMessageText(f Finding) string {
+	if f.Pinned == "" {
+		return fmt.Sprintf("Unpinned %s reference: %s", f.Type, f.Reason)
+	}
+	return fmt.Sprintf("Unpinned %s reference, pin it as: %s", f.Type, f.Pinned)
+}
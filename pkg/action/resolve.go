@@ -0,0 +1,243 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+)
+
+const (
+	// DefaultResolveConcurrency is the default maximum number of concurrent tag to SHA and image to digest lookups
+	DefaultResolveConcurrency = 4
+	// DefaultRegistryRetries is the default number of retries of registry requests failing with transient errors
+	DefaultRegistryRetries = 3
+	// registryRetryBackoff is the delay before the first retry of a registry request, doubled on every retry
+	registryRetryBackoff = time.Second
+)
+
+// transientStatusCodes are the registry response status codes worth retrying
+var transientStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// unresolvableStatusCodes are the registry response status codes of images which don't exist or can't be seen.
+// Unauthorized responses are not included, as they are also the challenges of the token authentication flow
+var unresolvableStatusCodes = map[int]bool{
+	http.StatusForbidden: true,
+	http.StatusNotFound:  true,
+}
+
+// manifestPathRegex matches the registry API path of the manifest of an image tag, capturing the repository and the
+// tag. Requests by digest are not matched
+var manifestPathRegex = regexp.MustCompile(`^/v2/(.+)/manifests/([^/:]+)$`)
+
+// ResolveErrors records the failures to resolve references, so they can be reported once the action completes.
+// References which don't exist, or can't be seen with the provided credentials, are recorded separately
+type ResolveErrors struct {
+	mu           sync.Mutex
+	errs         []string
+	unresolvable []string
+}
+
+// add records a resolution failure
+func (r *ResolveErrors) add(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+// addUnresolvable records a reference which doesn't exist or can't be seen, once
+func (r *ResolveErrors) addUnresolvable(ref string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.Contains(r.unresolvable, ref) {
+		r.unresolvable = append(r.unresolvable, ref)
+	}
+}
+
+// list returns the recorded resolution failures
+func (r *ResolveErrors) list() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.errs...)
+}
+
+// listUnresolvable returns the recorded references which don't exist or can't be seen
+func (r *ResolveErrors) listUnresolvable() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.unresolvable...)
+}
+
+// recordingREST is a GitHub REST client recording the actions whose ref can't be found
+type recordingREST struct {
+	interfaces.REST
+	errs *ResolveErrors
+}
+
+// RecordUnresolvableREST wraps the GitHub REST client used by the actions replacer to record the actions whose ref
+// is neither a tag nor a branch, or whose repository can't be seen with the provided token
+func RecordUnresolvableREST(rest interfaces.REST, errs *ResolveErrors) interfaces.REST {
+	return &recordingREST{REST: rest, errs: errs}
+}
+
+// Do executes the request, recording the ref if it is not found. The replacer looks for a branch once no tag is
+// found, so a branch which is not found means the ref can't be resolved at all
+func (r *recordingREST) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := r.REST.Do(ctx, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		if m := gitRefPathRegex.FindStringSubmatch(req.URL.Path); m != nil && strings.HasPrefix(m[3], "refs/heads/") {
+			r.errs.addUnresolvable(fmt.Sprintf("%s/%s@%s", m[1], m[2], strings.TrimPrefix(m[3], "refs/heads/")))
+		}
+	}
+	return resp, err
+}
+
+// Semaphore bounds the number of concurrent resolution requests, shared by all the files being parsed
+type Semaphore chan struct{}
+
+// NewSemaphore creates a semaphore allowing up to n concurrent requests
+func NewSemaphore(n int) Semaphore {
+	return make(Semaphore, n)
+}
+
+// acquire blocks until a slot is available or the context is done
+func (s Semaphore) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot
+func (s Semaphore) release() {
+	<-s
+}
+
+// limitedREST is a GitHub REST client bounding the number of concurrent requests
+type limitedREST struct {
+	interfaces.REST
+	sem Semaphore
+}
+
+// LimitREST wraps the GitHub REST client used by the actions replacer to bound its concurrent requests
+func LimitREST(rest interfaces.REST, sem Semaphore) interfaces.REST {
+	return &limitedREST{REST: rest, sem: sem}
+}
+
+// Do executes the request once a slot is available
+func (l *limitedREST) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if err := l.sem.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.sem.release()
+	return l.REST.Do(ctx, req)
+}
+
+// limitedTransport is an HTTP transport bounding the number of concurrent requests to container registries
+type limitedTransport struct {
+	base http.RoundTripper
+	sem  Semaphore
+}
+
+// LimitTransport wraps the HTTP transport used to talk to container registries to bound its concurrent requests
+func LimitTransport(base http.RoundTripper, sem Semaphore) http.RoundTripper {
+	return &limitedTransport{base: base, sem: sem}
+}
+
+// RoundTrip executes the request once a slot is available
+func (l *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := l.sem.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	defer l.sem.release()
+	return l.base.RoundTrip(req)
+}
+
+// retryTransport is an HTTP transport retrying the requests to container registries which fail with transient
+// errors, using exponential backoff
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	errs    *ResolveErrors
+}
+
+// RetryTransport wraps the HTTP transport used to talk to container registries to retry transient failures.
+// Requests still failing once the retries are exhausted are recorded in errs
+func RetryTransport(base http.RoundTripper, retries int, errs *ResolveErrors) http.RoundTripper {
+	return &retryTransport{base: base, retries: retries, errs: errs}
+}
+
+// RoundTrip executes the request, retrying it on transient errors. Only requests without a body, i.e. the manifest
+// GET and HEAD requests, are retried
+func (r *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := registryRetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := r.base.RoundTrip(req)
+		if err == nil && unresolvableStatusCodes[resp.StatusCode] {
+			if m := manifestPathRegex.FindStringSubmatch(req.URL.Path); m != nil {
+				r.errs.addUnresolvable(fmt.Sprintf("%s/%s:%s", req.URL.Host, m[1], m[2]))
+			}
+		}
+		transient := err != nil || transientStatusCodes[resp.StatusCode]
+		if !transient || req.Body != nil || req.Context().Err() != nil {
+			return resp, err
+		}
+		if attempt == r.retries {
+			status := ""
+			if err == nil {
+				status = resp.Status
+			} else {
+				status = err.Error()
+			}
+			log.Printf("Warning: giving up on %s %s after %d retries: %s", req.Method, req.URL, r.retries, status)
+			r.errs.add("%s %s: %s", req.Method, req.URL, status)
+			return resp, err
+		}
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+
+		// Wait before retrying, doubling the delay on every attempt
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
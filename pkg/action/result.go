@@ -0,0 +1,99 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"strings"
+
+	"github.com/stacklok/frizbee/pkg/replacer/image"
+)
+
+// Finding is an unpinned reference found by frizbee, along with the pinned reference it resolves to
+type Finding struct {
+	// File is the path of the file, relative to the repository root
+	File string `json:"file"`
+	// Line is the 1-based line number of the reference
+	Line int `json:"line"`
+	// Type is the type of the reference, i.e. action or container
+	Type string `json:"type"`
+	// Original is the original line containing the reference
+	Original string `json:"original"`
+	// Pinned is the line with the pinned reference, empty if the reference can't be pinned automatically
+	Pinned string `json:"pinned"`
+	// Reason explains how to pin the reference when it can't be pinned automatically
+	Reason string `json:"reason,omitempty"`
+}
+
+// pinnedText returns the pinned reference of the finding, or why it can't be pinned automatically
+func (f Finding) pinnedText() string {
+	if f.Pinned == "" {
+		return f.Reason
+	}
+	return "`" + findingRef(f.Pinned) + "`"
+}
+
+// findingsFromContent returns the findings of a file by comparing its original and modified content line by line
+func findingsFromContent(file, refType, original, modified string) []Finding {
+	var findings []Finding
+	originalLines := splitLines(original)
+	modifiedLines := splitLines(modified)
+	if len(originalLines) == len(modifiedLines) {
+		for i := range originalLines {
+			if originalLines[i] != modifiedLines[i] {
+				findings = append(findings, Finding{
+					File:     file,
+					Line:     i + 1,
+					Type:     referenceType(refType, originalLines[i]),
+					Original: strings.TrimSpace(originalLines[i]),
+					Pinned:   strings.TrimSpace(modifiedLines[i]),
+				})
+			}
+		}
+		return findings
+	}
+
+	// The number of lines changed, so pair the removed and added lines of the diff instead
+	var removed []diffOp
+	for _, op := range diffLines(originalLines, modifiedLines) {
+		switch op.kind {
+		case '-':
+			removed = append(removed, op)
+		case '+':
+			if len(removed) == 0 {
+				continue
+			}
+			findings = append(findings, Finding{
+				File:     file,
+				Line:     removed[0].oldLine + 1,
+				Type:     referenceType(refType, removed[0].line),
+				Original: strings.TrimSpace(removed[0].line),
+				Pinned:   strings.TrimSpace(op.line),
+			})
+			removed = removed[1:]
+		default:
+			removed = nil
+		}
+	}
+	return findings
+}
+
+// referenceType returns the type of the reference in the line, telling the CircleCI orbs apart from the images
+func referenceType(defaultType, line string) string {
+	if defaultType == image.ReferenceType && orbKeyRegex.MatchString(line) {
+		return orbReferenceType
+	}
+	return defaultType
+}
@@ -0,0 +1,255 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/v60/github"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+// createCommitOnBranchMutation creates a commit on a branch with the GitHub API, which signs it
+const createCommitOnBranchMutation = `mutation($input: CreateCommitOnBranchInput!) {
+  createCommitOnBranch(input: $input) { commit { oid } }
+}`
+
+// createCommitOnBranchData is the data of the response to the createCommitOnBranch mutation
+type createCommitOnBranchData struct {
+	CreateCommitOnBranch struct {
+		Commit struct {
+			OID string `json:"oid"`
+		} `json:"commit"`
+	} `json:"createCommitOnBranch"`
+}
+
+// pushSignedCommits creates the commits on the branch of the repository with the GitHub API rather than pushing them
+// with git, so they are signed by GitHub and show as verified. For the branch of the pull request, the commits are
+// added on top of its existing head, see rebaseOnBranch, otherwise on top of the checked out commit
+func (fa *FrizbeeAction) pushSignedCommits(ctx context.Context, commits []pull_request.Commit, branch string,
+	pullRequest bool) error {
+	base, err := pull_request.HeadSHA()
+	if err != nil {
+		return fmt.Errorf("failed to get the checked out commit: %w", err)
+	}
+	changes := make([]commitFiles, len(commits))
+	for i, c := range commits {
+		changes[i].files = c.Files
+		// The last commit includes all the remaining changes of the working tree
+		if i == len(commits)-1 {
+			var changed []string
+			changed, changes[i].deleted, err = pull_request.WorkingTreeChanges()
+			if err != nil {
+				return err
+			}
+			changes[i].files = make(map[string]string, len(changed))
+			for _, path := range changed {
+				content, err := os.ReadFile(path) // nolint:gosec
+				if err != nil {
+					return fmt.Errorf("failed to read file %s: %w", path, err)
+				}
+				changes[i].files[path] = string(content)
+			}
+		}
+	}
+	head := base
+	if pullRequest {
+		if head, err = fa.rebaseOnBranch(ctx, branch, base, changes); err != nil {
+			return err
+		}
+	}
+
+	for i, c := range commits {
+		if len(changes[i].files) == 0 && len(changes[i].deleted) == 0 {
+			log.Printf("Branch %s already has the changes of %q, not committing them again", branch, c.Message)
+			continue
+		}
+		head, err = fa.createCommitOnBranch(ctx, branch, head, c.Message, changes[i].files, changes[i].deleted)
+		if err != nil {
+			return err
+		}
+		log.Printf("Created signed commit %s on %s", head, branch)
+	}
+	return nil
+}
+
+// commitFiles are the changes of a commit made with the GitHub API, the content of its files by path and the paths of
+// its deleted files
+type commitFiles struct {
+	files   map[string]string
+	deleted []string
+}
+
+// rebaseOnBranch returns the commit the commits made on top of the base commit are added on top of with the GitHub
+// API, which is the head of the branch if it exists, so the commits pushed to it since are kept, i.e. the fixups of
+// the reviewers. The changes the branch already has are dropped. If the branch changed any of the files differently,
+// it is only reset to the base commit if ForcePush is set. The branch is created at the base commit if it doesn't exist
+func (fa *FrizbeeAction) rebaseOnBranch(ctx context.Context, branch, base string, changes []commitFiles) (string,
+	error) {
+	ref, resp, err := fa.Client.Git.GetRef(ctx, fa.RepoOwner, fa.RepoName, "heads/"+branch)
+	if err != nil {
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return "", fmt.Errorf("failed to get branch %s: %w", branch, err)
+		}
+		return base, fa.resetBranch(ctx, branch, base)
+	}
+	head := ref.GetObject().GetSHA()
+	if head == base {
+		return head, nil
+	}
+	rebased := make([]commitFiles, len(changes))
+	for i, c := range changes {
+		if rebased[i], err = fa.rebaseFiles(ctx, base, head, c); err != nil {
+			if !errors.Is(err, pull_request.ErrBranchDiverged) {
+				return "", err
+			}
+			if !fa.ForcePush {
+				return "", fmt.Errorf("%w: %s can't be rebased, set force_push to overwrite it", err, branch)
+			}
+			log.Printf("Warning: %v: %s can't be rebased, overwriting it", err, branch)
+			return base, fa.resetBranch(ctx, branch, base)
+		}
+	}
+	copy(changes, rebased)
+	return head, nil
+}
+
+// rebaseFiles returns the changes made on top of the base commit to apply on top of the head commit instead, without
+// the ones the head already has. It returns ErrBranchDiverged if the head changed any of the files differently
+func (fa *FrizbeeAction) rebaseFiles(ctx context.Context, base, head string, c commitFiles) (commitFiles, error) {
+	rebased := commitFiles{files: make(map[string]string, len(c.files))}
+	for path, content := range c.files {
+		apply, err := fa.rebaseFile(ctx, base, head, path, content, true)
+		if err != nil {
+			return commitFiles{}, err
+		}
+		if apply {
+			rebased.files[path] = content
+		}
+	}
+	for _, path := range c.deleted {
+		apply, err := fa.rebaseFile(ctx, base, head, path, "", false)
+		if err != nil {
+			return commitFiles{}, err
+		}
+		if apply {
+			rebased.deleted = append(rebased.deleted, path)
+		}
+	}
+	return rebased, nil
+}
+
+// rebaseFile returns whether the change of the file, to the given content or deleted if not exists, must be applied
+// on top of the head commit, which is when the file is the same on the head and the base commits
+func (fa *FrizbeeAction) rebaseFile(ctx context.Context, base, head, path, content string, exists bool) (bool, error) {
+	atHead, onHead, err := fa.fileAt(ctx, path, head)
+	if err != nil {
+		return false, err
+	}
+	if onHead == exists && atHead == content {
+		return false, nil
+	}
+	atBase, onBase, err := fa.fileAt(ctx, path, base)
+	if err != nil {
+		return false, err
+	}
+	if onHead != onBase || atHead != atBase {
+		return false, fmt.Errorf("%w: %s was changed on %s", pull_request.ErrBranchDiverged, path, head)
+	}
+	return true, nil
+}
+
+// fileAt returns the content of the file at the commit, and whether it exists
+func (fa *FrizbeeAction) fileAt(ctx context.Context, path, sha string) (string, bool, error) {
+	file, _, resp, err := fa.Client.Repositories.GetContents(ctx, fa.RepoOwner, fa.RepoName, filepath.ToSlash(path),
+		&github.RepositoryContentGetOptions{Ref: sha})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to get file %s at %s: %w", path, sha, err)
+	}
+	if file == nil {
+		return "", false, fmt.Errorf("%s is a directory at %s", path, sha)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decode file %s at %s: %w", path, sha, err)
+	}
+	return content, true, nil
+}
+
+// resetBranch points the branch to the commit, creating it if needed
+func (fa *FrizbeeAction) resetBranch(ctx context.Context, branch, sha string) error {
+	ref := &github.Reference{Ref: github.String("refs/heads/" + branch), Object: &github.GitObject{SHA: &sha}}
+	_, resp, err := fa.Client.Git.GetRef(ctx, fa.RepoOwner, fa.RepoName, "heads/"+branch)
+	if err != nil {
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("failed to get branch %s: %w", branch, err)
+		}
+		if _, _, err := fa.Client.Git.CreateRef(ctx, fa.RepoOwner, fa.RepoName, ref); err != nil {
+			return fmt.Errorf("failed to create branch %s: %w", branch, err)
+		}
+		return nil
+	}
+	if _, _, err := fa.Client.Git.UpdateRef(ctx, fa.RepoOwner, fa.RepoName, ref, true); err != nil {
+		return fmt.Errorf("failed to reset branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+// createCommitOnBranch creates a commit with the given files on the branch, whose head must be the expected commit,
+// and returns the SHA of the new commit
+func (fa *FrizbeeAction) createCommitOnBranch(ctx context.Context, branch, expectedHead, message string,
+	files map[string]string, deleted []string) (string, error) {
+	headline, body, _ := strings.Cut(pull_request.FullMessage(message), "\n")
+	additions := make([]map[string]string, 0, len(files))
+	for path, content := range files {
+		additions = append(additions, map[string]string{
+			"path":     strings.TrimPrefix(path, "./"),
+			"contents": base64.StdEncoding.EncodeToString([]byte(content)),
+		})
+	}
+	deletions := make([]map[string]string, 0, len(deleted))
+	for _, path := range deleted {
+		deletions = append(deletions, map[string]string{"path": path})
+	}
+	input := map[string]any{
+		"branch": map[string]string{
+			"repositoryNameWithOwner": fa.RepoOwner + "/" + fa.RepoName,
+			"branchName":              branch,
+		},
+		"message":         map[string]string{"headline": headline, "body": strings.TrimSpace(body)},
+		"fileChanges":     map[string]any{"additions": additions, "deletions": deletions},
+		"expectedHeadOid": expectedHead,
+	}
+
+	var data createCommitOnBranchData
+	err := fa.graphQL(ctx, createCommitOnBranchMutation, map[string]any{"input": input}, &data)
+	if err != nil {
+		return "", fmt.Errorf("failed to create commit on %s: %w", branch, err)
+	}
+	return data.CreateCommitOnBranch.Commit.OID, nil
+}
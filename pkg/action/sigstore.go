@@ -0,0 +1,122 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/stacklok/frizbee/pkg/replacer/image"
+)
+
+// withVerifiedSignatures reverts the lines of the file whose image references were pinned to a digest without a valid
+// cosign signature from the expected identity, if the VerifySigstore flag is set. The reverted references are
+// reported as findings which can't be pinned automatically. It returns the content without the unverified pins, or
+// the original content if the lines of both don't match, reporting its pins as unverified
+func (fa *FrizbeeAction) withVerifiedSignatures(ctx context.Context, path, repoPath, original, content string) string {
+	if !fa.VerifySigstore {
+		return content
+	}
+	originalLines := strings.Split(original, "\n")
+	lines := strings.Split(content, "\n")
+	if len(originalLines) != len(lines) {
+		log.Printf("Warning: not pinning %s, the lines of the pinned content don't match the original ones", path)
+		// The original lines missing from the pinned content are the ones of the references which were pinned
+		for i, line := range originalLines {
+			if slices.Contains(lines, line) || strings.TrimSpace(line) == "" {
+				continue
+			}
+			fa.findings = append(fa.findings, Finding{
+				File:     repoPath,
+				Line:     i + 1,
+				Type:     image.ReferenceType,
+				Original: strings.TrimSpace(line),
+				Reason:   "The signature of the image can't be verified: the pinned lines don't match the file",
+			})
+		}
+		return original
+	}
+	if fa.signatures == nil {
+		fa.signatures = make(map[string]string)
+	}
+
+	for i, line := range lines {
+		if line == originalLines[i] {
+			continue
+		}
+		for _, ref := range pinnedImageRegex.FindAllString(line, -1) {
+			ref = strings.TrimPrefix(ref, "docker://")
+			reason, ok := fa.signatures[ref]
+			if !ok {
+				reason = fa.verifySignature(ctx, ref)
+				fa.signatures[ref] = reason
+			}
+			if reason == "" {
+				continue
+			}
+			log.Printf("Warning: not pinning line %d of %s, the signature of %s can't be verified: %s", i+1, path,
+				ref, reason)
+			fa.findings = append(fa.findings, Finding{
+				File:     repoPath,
+				Line:     i + 1,
+				Type:     image.ReferenceType,
+				Original: strings.TrimSpace(originalLines[i]),
+				Reason:   "The signature of the image can't be verified: " + reason,
+			})
+			lines[i] = originalLines[i]
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// verifySignature verifies the cosign signature of the image pinned to a digest against the identity and issuer of
+// the policy, and returns why it failed or an empty string if the signature is valid
+func (fa *FrizbeeAction) verifySignature(ctx context.Context, ref string) string {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "cosign", "verify",
+		"--certificate-identity-regexp", fa.SigstoreIdentity,
+		"--certificate-oidc-issuer", fa.SigstoreIssuer, ref)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			lines := strings.Split(msg, "\n")
+			return lines[len(lines)-1]
+		}
+		return err.Error()
+	}
+	return ""
+}
+
+// checkSigstorePolicy makes sure the identity and issuer the signatures are verified against are set, and that cosign
+// is available
+func (fa *FrizbeeAction) checkSigstorePolicy() error {
+	if !fa.VerifySigstore {
+		return nil
+	}
+	if fa.SigstoreIdentity == "" || fa.SigstoreIssuer == "" {
+		return fmt.Errorf("verifying the signatures requires both the certificate identity and issuer to be set")
+	}
+	if _, err := exec.LookPath("cosign"); err != nil {
+		return fmt.Errorf("verifying the signatures requires cosign: %w", err)
+	}
+	return nil
+}
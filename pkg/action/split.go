@@ -0,0 +1,224 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/stacklok/frizbee-action/pkg/pull_request"
+)
+
+const (
+	// SplitPRsCategory opens a pull request for each category of the changes, i.e. the pinned actions and images
+	SplitPRsCategory = "category"
+	// SplitPRsDirectory opens a pull request for each directory of the changed files, i.e. each service of a monorepo
+	SplitPRsDirectory = "directory"
+	// SplitPRsBatch opens pull requests of at most MaxChangesPerPR pinned references each
+	SplitPRsBatch = "batch"
+	// DefaultGroupDepth is the default number of components of the directories the changed files are grouped by
+	DefaultGroupDepth = 1
+	// rootGroup names the group of the changed files at the root of the repository in the branch names
+	rootGroup = "root"
+)
+
+// pullRequestGroup is a part of the changes opened as its own pull request
+type pullRequestGroup struct {
+	// category is the category of the changes of the pull request, if split by category
+	category commitCategory
+	// directory is the directory of the changed files of the pull request, if split by directory
+	directory string
+	// batch is the number of the batch of the pull request starting from 1, if split in batches
+	batch int
+	// batches is the number of batches
+	batches int
+	// files is the content of the changed files of the pull request by path
+	files map[string]string
+}
+
+// suffix returns the suffix of the branch of the pull request of the group, or an empty string if the changes are not
+// split
+func (g pullRequestGroup) suffix() string {
+	switch {
+	case g.category.category != "":
+		return g.category.category
+	case g.directory == ".":
+		return rootGroup
+	case g.batch > 0:
+		return fmt.Sprintf("batch-%d", g.batch)
+	case g.directory != "":
+		// The components of a branch name can't start with a dot
+		components := strings.Split(g.directory, "/")
+		for i, c := range components {
+			components[i] = strings.TrimLeft(c, ".")
+		}
+		return strings.Join(components, "-")
+	}
+	return ""
+}
+
+// contains returns whether the finding belongs to the group, all of them belonging to it if the changes are not split
+func (g pullRequestGroup) contains(f Finding) bool {
+	if g.category.refType != "" && f.Type != g.category.refType {
+		return false
+	}
+	if _, ok := g.files[f.File]; g.files != nil && !ok {
+		return false
+	}
+	return true
+}
+
+// pullRequestGroups splits the changes by category, directory or in batches, in the order the pull requests are
+// opened. Each group has the changes of its category or its files only, the changes made by the hooks being left out
+func (fa *FrizbeeAction) pullRequestGroups(pinned []Finding) []pullRequestGroup {
+	var groups []pullRequestGroup
+	switch fa.SplitPRs {
+	case SplitPRsCategory:
+		lineTypes, fileTypes := lineCategories(append(slices.Clone(pinned), fa.refreshed...))
+		for _, cat := range commitCategories {
+			group := pullRequestGroup{category: cat, files: make(map[string]string)}
+			for _, c := range fa.changes {
+				content, changed := partialContent(c, lineTypes[c.Path], fileTypes[c.Path], cat.refType,
+					map[string]bool{cat.refType: true})
+				if changed {
+					group.files[c.Path] = content
+				}
+			}
+			if len(group.files) > 0 {
+				groups = append(groups, group)
+			}
+		}
+	case SplitPRsDirectory:
+		byDirectory := make(map[string]map[string]string)
+		for _, c := range fa.changes {
+			directory := groupDirectory(c.Path, fa.GroupDepth)
+			if byDirectory[directory] == nil {
+				byDirectory[directory] = make(map[string]string)
+			}
+			byDirectory[directory][c.Path] = c.Modified
+		}
+		for directory, files := range byDirectory {
+			groups = append(groups, pullRequestGroup{directory: directory, files: files})
+		}
+		slices.SortFunc(groups, func(a, b pullRequestGroup) int {
+			return strings.Compare(a.directory, b.directory)
+		})
+	case SplitPRsBatch:
+		groups = fa.batches(append(slices.Clone(pinned), fa.refreshed...))
+	}
+	return groups
+}
+
+// batches packs the changed files in batches of at most MaxChangesPerPR pinned references, in the order they were
+// changed. The files are kept whole so the pull requests don't conflict, a file with more pins than the maximum
+// making a batch of its own
+func (fa *FrizbeeAction) batches(findings []Finding) []pullRequestGroup {
+	pins := make(map[string]int)
+	for _, f := range findings {
+		if f.Pinned != "" {
+			pins[f.File]++
+		}
+	}
+	var groups []pullRequestGroup
+	count := 0
+	for _, c := range fa.changes {
+		if len(groups) == 0 || (count > 0 && count+pins[c.Path] > fa.MaxChangesPerPR) {
+			groups = append(groups, pullRequestGroup{batch: len(groups) + 1, files: make(map[string]string)})
+			count = 0
+		}
+		groups[len(groups)-1].files[c.Path] = c.Modified
+		count += pins[c.Path]
+	}
+	for i := range groups {
+		groups[i].batches = len(groups)
+	}
+	return groups
+}
+
+// groupDirectory returns the directory grouping the file, made of the first components of its directory up to the
+// given depth, or "." for the files at the root of the repository
+func groupDirectory(file string, depth int) string {
+	dir := path.Dir(filepath.ToSlash(file))
+	if dir == "." {
+		return dir
+	}
+	components := strings.Split(dir, "/")
+	if len(components) > depth {
+		components = components[:depth]
+	}
+	return strings.Join(components, "/")
+}
+
+// pushGroupPullRequests opens a pull request for each group of the changes, from a branch named after the branch of
+// the pull request with the group as suffix, as the parts of the changes are often owned by different teams. Each
+// branch starts from the checked out commit with the changes of its group only
+func (fa *FrizbeeAction) pushGroupPullRequests(ctx context.Context, pinned []Finding) error {
+	// The commits of the remote write mode are made of the files of the group, without a working tree
+	var base string
+	if fa.WriteMode != WriteModeRemote {
+		var err error
+		if base, err = pull_request.HeadSHA(); err != nil {
+			return fmt.Errorf("failed to get the checked out commit: %w", err)
+		}
+	}
+	for _, group := range fa.pullRequestGroups(pinned) {
+		fa.group = group
+		log.Printf("Opening the pull request of %s", group.suffix())
+
+		if base != "" {
+			if err := pull_request.CheckoutClean(base); err != nil {
+				return err
+			}
+			// Leave the changes of the group only in the working tree, which the last commit includes
+			for path, content := range group.files {
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil { // nolint:gosec
+					return fmt.Errorf("failed to write file %s: %w", path, err)
+				}
+			}
+		}
+		message, err := fa.commitMessage(pinned)
+		if err != nil {
+			return err
+		}
+		commits := []pull_request.Commit{{Message: fa.withProvenance(message), Files: group.files}}
+		if commits, err = fa.withChangelogFragment(pinned, commits); err != nil {
+			return err
+		}
+		if err := fa.pushPullRequest(ctx, fa.groupedFindings(pinned), commits); err != nil {
+			return err
+		}
+	}
+	fa.group = pullRequestGroup{}
+	return nil
+}
+
+// groupedFindings returns the findings of the group of the pull request being opened, or all of them if the changes
+// are not split
+func (fa *FrizbeeAction) groupedFindings(findings []Finding) []Finding {
+	var filtered []Finding
+	for _, f := range findings {
+		if fa.group.contains(f) {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,199 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/replacer/image"
+)
+
+const (
+	// maxSummaryDiffBytes caps the size of the diffs included in the job summary, which GitHub limits to 1MiB
+	maxSummaryDiffBytes = 512 * 1024
+	// SummaryByFile lists the findings in the job summary in the order of the files they were found in
+	SummaryByFile = "file"
+	// SummaryByReference groups the identical references of the findings in the job summary
+	SummaryByReference = "reference"
+	// SummaryByType groups the findings in the job summary by the type of reference, i.e. action or container
+	SummaryByType = "type"
+)
+
+// FindingGroup is a set of identical unpinned references found in one or more files
+type FindingGroup struct {
+	// Type is the type of the reference, i.e. action or container
+	Type string `json:"type"`
+	// Original is the unpinned reference
+	Original string `json:"original"`
+	// Pinned is the pinned reference it resolves to
+	Pinned string `json:"pinned"`
+	// Files is the list of the `file:line` locations of the reference
+	Files []string `json:"files"`
+}
+
+// groupFindings groups the findings by their original and pinned references, in the order they were found
+func groupFindings(findings []Finding) []FindingGroup {
+	var groups []FindingGroup
+	index := make(map[string]int)
+	for _, f := range findings {
+		original, pinned := findingRef(f.Original), findingRef(f.Pinned)
+		key := original + "\x00" + pinned
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, FindingGroup{Type: f.Type, Original: original, Pinned: pinned})
+		}
+		groups[i].Files = append(groups[i].Files, fmt.Sprintf("%s:%d", filepath.ToSlash(f.File), f.Line))
+	}
+	return groups
+}
+
+// writeStepSummary appends the summary of the findings to the job summary, when running in GitHub Actions
+func (fa *FrizbeeAction) writeStepSummary() error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open job summary %s: %w", path, err)
+	}
+	defer f.Close() // nolint:errcheck
+	if _, err := f.WriteString(fa.summary()); err != nil {
+		return fmt.Errorf("failed to write job summary %s: %w", path, err)
+	}
+	return nil
+}
+
+// summary returns the Markdown summary of the findings, organized according to the SummaryGroupBy setting. The
+// DedupeFindings flag always groups the findings by reference
+func (fa *FrizbeeAction) summary() string {
+	var b strings.Builder
+	b.WriteString("## Frizbee\n\n")
+	fmt.Fprintf(&b, "Scanned %d files.\n\n", len(fa.processedFiles))
+	if _, total := fa.coverage(); total.AlreadyPinned > 0 {
+		fmt.Fprintf(&b, "%d references were already pinned, %d%% of all the references.\n\n", total.AlreadyPinned,
+			total.pinnedPercent())
+	}
+	if len(fa.refreshed) > 0 {
+		fmt.Fprintf(&b, "Advanced %d pins whose tag moved.\n\n%s\n", len(fa.refreshed), findingsTable(fa.refreshed))
+	}
+	if refs := fa.ResolveErrors.listUnresolvable(); len(refs) > 0 {
+		fmt.Fprintf(&b, "Skipped %d references which can't be resolved: `%s`.\n\n", len(refs),
+			strings.Join(refs, "`, `"))
+	}
+	if len(fa.findings) > 0 {
+		fmt.Fprintf(&b, "Found %d unpinned references in %d files.\n\n", len(fa.findings), len(fa.changes))
+	}
+	switch {
+	case fa.NoticeOnSuccess && fa.clean():
+		b.WriteString("| Status |\n|--------|\n| :white_check_mark: All the references are pinned |\n")
+	case len(fa.findings) == 0:
+		b.WriteString("All the references are pinned.\n")
+	case fa.SummaryGroupBy == SummaryByReference || fa.DedupeFindings:
+		b.WriteString(referenceTable(groupFindings(fa.findings)))
+	case fa.SummaryGroupBy == SummaryByType:
+		for _, t := range []struct{ refType, heading string }{
+			{actions.ReferenceType, "Actions"},
+			{image.ReferenceType, "Container images"},
+			{chartReferenceType, "Helm charts"},
+			{orbReferenceType, "CircleCI orbs"},
+		} {
+			var findings []Finding
+			for _, f := range fa.findings {
+				if f.Type == t.refType {
+					findings = append(findings, f)
+				}
+			}
+			if len(findings) > 0 {
+				fmt.Fprintf(&b, "### %s\n\n%s\n", t.heading, findingsTable(findings))
+			}
+		}
+	default:
+		b.WriteString(findingsTable(fa.findings))
+	}
+	if fa.SummaryIncludeDiff && len(fa.changes) > 0 {
+		b.WriteString(fa.summaryDiffs())
+	}
+	return b.String()
+}
+
+// clean returns true if the run found no unpinned references and resolved all the references
+func (fa *FrizbeeAction) clean() bool {
+	if len(fa.findings) > 0 || len(fa.ResolveErrors.list()) > 0 {
+		return false
+	}
+	return fa.IgnoreUnresolvable || len(fa.ResolveErrors.listUnresolvable()) == 0
+}
+
+// notice emits a notice annotation on the run. The workflow commands are written to the standard error when the
+// standard output is reserved for the JSON report
+func (fa *FrizbeeAction) notice(title, message string) {
+	var w io.Writer = os.Stdout
+	if fa.JSONStdout {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "::notice title=%s::%s\n", title, message) // nolint:errcheck
+}
+
+// summaryDiffs returns the diff of each modified file in a collapsed block, omitting the diffs exceeding the size cap
+func (fa *FrizbeeAction) summaryDiffs() string {
+	var b strings.Builder
+	b.WriteString("\n### Changes\n\n")
+	for i, c := range fa.changes {
+		diff := unifiedDiff(filepath.ToSlash(c.Path), c.Original, c.Modified, fa.DiffContext)
+		block := fmt.Sprintf("<details>\n<summary>%s</summary>\n\n```diff\n%s```\n\n</details>\n\n",
+			filepath.ToSlash(c.Path), diff)
+		if b.Len()+len(block) > maxSummaryDiffBytes {
+			fmt.Fprintf(&b, "The diffs of %d more files are omitted to keep the summary small.\n", len(fa.changes)-i)
+			break
+		}
+		b.WriteString(block)
+	}
+	return b.String()
+}
+
+// findingsTable returns a Markdown table with a row per finding
+func findingsTable(findings []Finding) string {
+	var b strings.Builder
+	b.WriteString("| File | Type | Reference | Pinned |\n|------|------|-----------|--------|\n")
+	for _, f := range findings {
+		fmt.Fprintf(&b, "| `%s:%d` | %s | `%s` | %s |\n", filepath.ToSlash(f.File), f.Line, f.Type,
+			findingRef(f.Original), f.pinnedText())
+	}
+	return b.String()
+}
+
+// referenceTable returns a Markdown table with a row per group of identical references, listing the affected files
+func referenceTable(groups []FindingGroup) string {
+	var b strings.Builder
+	b.WriteString("| Type | Reference | Pinned | Files |\n|------|-----------|--------|-------|\n")
+	for _, g := range groups {
+		files := "`" + g.Files[0] + "`"
+		if len(g.Files) > 1 {
+			files = fmt.Sprintf("<details><summary>%d files</summary>%s</details>", len(g.Files),
+				"`"+strings.Join(g.Files, "`<br>`")+"`")
+		}
+		fmt.Fprintf(&b, "| %s | `%s` | `%s` | %s |\n", g.Type, g.Original, g.Pinned, files)
+	}
+	return b.String()
+}
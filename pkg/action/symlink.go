@@ -0,0 +1,58 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// canonicalPath returns the path of the file with all its symlinks resolved, relative to the repository root when
+// the target lives in the repository. The returned flag is false if the file is a symlink which must not be followed
+func (fa *FrizbeeAction) canonicalPath(path string) (string, bool, error) {
+	canonical, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to resolve symlinks of %s: %w", path, err)
+	}
+	if filepath.IsAbs(canonical) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, canonical); err == nil && !strings.HasPrefix(rel, "..") {
+				canonical = rel
+			}
+		}
+	}
+	if canonical == filepath.Clean(path) {
+		return canonical, true, nil
+	}
+	if !fa.FollowSymlinks {
+		log.Printf("Skipping %s: it is a symlink to %s", path, canonical)
+		return canonical, false, nil
+	}
+	return canonical, true, nil
+}
+
+// visit marks the canonical path of a file as handled, returning false if it was already handled, e.g. through a
+// symlink pointing to it
+func visit(seen map[string]bool, canonical string) bool {
+	if seen[canonical] {
+		return false
+	}
+	seen[canonical] = true
+	return true
+}
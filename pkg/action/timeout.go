@@ -0,0 +1,111 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/stacklok/frizbee/pkg/replacer"
+)
+
+// parseFunc parses the files in a path, returning the paths relative to the parent of the given path
+type parseFunc func(ctx context.Context, path string) (*replacer.ReplaceResult, error)
+
+// parseWithTimeout parses the files in the path one by one, abandoning the files taking longer than the per-file
+// timeout. Abandoned files are reported as skipped and their results are discarded, so they are never written.
+// Without a per-file timeout the whole path is parsed at once
+func (fa *FrizbeeAction) parseWithTimeout(
+	ctx context.Context, path string, match func(fileName string) bool, parse parseFunc,
+) (*replacer.ReplaceResult, error) {
+	if fa.TimeoutPerFile == 0 {
+		return parse(ctx, path)
+	}
+
+	res := &replacer.ReplaceResult{
+		Processed: make([]string, 0),
+		Modified:  make(map[string]string),
+	}
+	err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !match(entry.Name()) {
+			return nil
+		}
+		// The results of a single file are relative to its own directory, so make them relative to the parent
+		// of the path like the results of the whole path
+		relDir, err := filepath.Rel(filepath.Dir(path), filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+
+		fileRes, err := parseFileWithTimeout(ctx, file, fa.TimeoutPerFile, parse)
+		if err != nil {
+			return err
+		}
+		if fileRes == nil {
+			log.Printf("Warning: skipping %s, processing it took longer than %s", file, fa.TimeoutPerFile)
+			fa.skippedFiles = append(fa.skippedFiles, file)
+			return nil
+		}
+		for _, p := range fileRes.Processed {
+			res.Processed = append(res.Processed, filepath.Join(relDir, p))
+		}
+		for p, content := range fileRes.Modified {
+			res.Modified[filepath.Join(relDir, p)] = content
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse files in %s: %w", path, err)
+	}
+	return res, nil
+}
+
+// parseFileWithTimeout parses a single file, returning a nil result if it takes longer than the timeout
+func parseFileWithTimeout(
+	ctx context.Context, file string, timeout time.Duration, parse parseFunc,
+) (*replacer.ReplaceResult, error) {
+	fileCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		res *replacer.ReplaceResult
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := parse(fileCtx, file)
+		done <- result{res, err}
+	}()
+
+	select {
+	case r := <-done:
+		// The replacers skip the references failing to resolve, so a result obtained once the deadline passed may
+		// only be partially pinned
+		if fileCtx.Err() != nil && ctx.Err() == nil {
+			return nil, nil
+		}
+		return r.res, r.err
+	case <-fileCtx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	}
+}
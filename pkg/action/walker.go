@@ -0,0 +1,265 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"gopkg.in/yaml.v3"
+)
+
+// lineWalker pins the container images referenced in file formats the frizbee replacers can't parse.
+// It walks the files line by line, so comments and formatting are preserved
+type lineWalker struct {
+	// name is the name of the file format, used for logging
+	name string
+	// match returns true if the file with the given name should be processed
+	match func(fileName string) bool
+	// regex matches the image references, its first subgroup which matched being the image reference to pin
+	regex *regexp.Regexp
+	// skip returns true if the line should be left untouched, i.e. it is a comment
+	skip func(line string) bool
+	// cComments makes the walker skip `//` line comments and `/* */` block comments
+	cComments bool
+	// block, if set, restricts the walker to the brace-delimited blocks opened by the lines it matches
+	block *regexp.Regexp
+	// verbose logs every match, for the formats matched heuristically
+	verbose bool
+	// images, if set, returns the image references of each document of the YAML formats instead of the regex
+	images func(doc *yaml.Node) []*yaml.Node
+	// orbs, if set, returns the CircleCI orb references of each document of the YAML formats
+	orbs func(doc *yaml.Node) []*yaml.Node
+}
+
+// quotedStringRegex matches double-quoted strings, so the braces they contain are not counted as blocks
+var quotedStringRegex = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+
+// devcontainerWalker pins the `image` field of dev container configurations. The configurations are JSON with
+// comments, so lines are only updated in place to never corrupt comments or trailing commas
+var devcontainerWalker = lineWalker{
+	name: "dev container",
+	match: func(fileName string) bool {
+		return fileName == "devcontainer.json" || fileName == ".devcontainer.json"
+	},
+	regex:     regexp.MustCompile(`"image"\s*:\s*"([^"]+)"`),
+	cComments: true,
+}
+
+// quadletWalker pins the `Image=` directive of Podman Quadlet `.container` and `.image` units. Values referencing
+// Quadlet variables or systemd specifiers, as well as references to other `.image` or `.build` units, are skipped
+var quadletWalker = lineWalker{
+	name: "Quadlet",
+	match: func(fileName string) bool {
+		return strings.HasSuffix(fileName, ".container") || strings.HasSuffix(fileName, ".image")
+	},
+	regex: regexp.MustCompile(`^\s*Image\s*=\s*([^\s%$]+)\s*$`),
+	skip: func(line string) bool {
+		trimmed := strings.TrimSpace(line)
+		return strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") ||
+			strings.HasSuffix(trimmed, ".image") || strings.HasSuffix(trimmed, ".build")
+	},
+}
+
+// terraformWalker pins the `image` arguments of the Terraform resources running containers, i.e. `docker_container`
+// or `kubernetes_manifest`. Only literal values are pinned, values using interpolation or variables are skipped
+var terraformWalker = lineWalker{
+	name: "Terraform",
+	match: func(fileName string) bool {
+		return strings.HasSuffix(fileName, ".tf")
+	},
+	regex: regexp.MustCompile(`^\s*"?image"?\s*=\s*"([^"$%{}]+)"`),
+	skip: func(line string) bool {
+		return strings.HasPrefix(strings.TrimSpace(line), "#")
+	},
+	cComments: true,
+	block:     regexp.MustCompile(`^\s*resource\s+"(docker_container|docker_service|kubernetes_[a-z0-9_]+)"`),
+}
+
+// defaultShellScriptPatterns are the patterns matching the image references of shell scripts by default. They only
+// match references with an explicit tag, in the commands known to take an image, to avoid false positives
+var defaultShellScriptPatterns = []string{
+	// buildah from [--flag[=value]...] image:tag
+	`\bbuildah\s+from\s+(?:--[\w-]+(?:=\S+)?\s+)*([\w][\w./-]*:[\w][\w.-]*)(?:[\s)"';|&]|$)`,
+	// docker pull or podman pull image:tag
+	`\b(?:docker|podman)\s+pull\s+(?:--[\w-]+(?:=\S+)?\s+)*([\w][\w./-]*:[\w][\w.-]*)(?:[\s)"';|&]|$)`,
+	// --build-arg *IMAGE*=image:tag or --build-arg *BASE*=image:tag
+	`--build-arg[\s=]+["']?\w*(?:IMAGE|BASE)\w*=([\w][\w./-]*:[\w][\w.-]*)(?:[\s)"';|&]|$)`,
+}
+
+// ShellScriptRegex compiles the patterns matching the image references of shell scripts into a single regex, using
+// the default patterns if none is given. Each pattern must have exactly one capturing group, the image reference
+func ShellScriptRegex(patterns []string) (*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		patterns = defaultShellScriptPatterns
+	}
+	alternatives := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		if re.NumSubexp() != 1 {
+			return nil, fmt.Errorf("pattern %q must have exactly one capturing group, the image reference", p)
+		}
+		alternatives = append(alternatives, "(?:"+p+")")
+	}
+	return regexp.Compile(strings.Join(alternatives, "|"))
+}
+
+// isShellScript returns true if the file name is the name of a shell script
+func isShellScript(fileName string) bool {
+	return strings.HasSuffix(fileName, ".sh") || strings.HasSuffix(fileName, ".bash")
+}
+
+// shellScriptWalker pins the image references of shell scripts matched by the regex, i.e. in `buildah from` or
+// `docker build --build-arg BASE=` commands. The matches are heuristic, so each one is logged to be verified
+func shellScriptWalker(regex *regexp.Regexp) lineWalker {
+	return lineWalker{
+		name:  "shell script",
+		match: isShellScript,
+		regex: regex,
+		skip: func(line string) bool {
+			return strings.HasPrefix(strings.TrimSpace(line), "#")
+		},
+		verbose: true,
+	}
+}
+
+// parseWithWalker walks the given path and pins the image references matched by the walker.
+// It returns a result the same way the replacers do, with paths relative to the parent of the given path
+func (fa *FrizbeeAction) parseWithWalker(
+	ctx context.Context, path string, w lineWalker,
+) (*replacer.ReplaceResult, error) {
+	res := &replacer.ReplaceResult{
+		Processed: make([]string, 0),
+		Modified:  make(map[string]string),
+	}
+
+	err := filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !w.match(entry.Name()) {
+			return nil
+		}
+		content, err := os.ReadFile(p) // nolint:gosec
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", p, err)
+		}
+		rel, err := filepath.Rel(filepath.Dir(path), p)
+		if err != nil {
+			return err
+		}
+		res.Processed = append(res.Processed, rel)
+		if newContent, modified := fa.pinLines(ctx, string(content), w); modified {
+			res.Modified[rel] = newContent
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s files in %s: %w", w.name, path, err)
+	}
+	return res, nil
+}
+
+// pinLines pins the image references matched by the walker in the content.
+// It returns the updated content and whether any reference was pinned
+func (fa *FrizbeeAction) pinLines(ctx context.Context, content string, w lineWalker) (string, bool) {
+	if w.images != nil {
+		return fa.pinYAMLReferences(ctx, content, w)
+	}
+	lines := strings.Split(content, "\n")
+	modified := false
+	inBlockComment := false
+	depth, blockDepth := 0, -1
+	for i, line := range lines {
+		if w.cComments {
+			trimmed := strings.TrimSpace(line)
+			if inBlockComment || strings.HasPrefix(trimmed, "/*") {
+				inBlockComment = !strings.Contains(trimmed, "*/")
+				continue
+			}
+			if strings.HasPrefix(trimmed, "//") {
+				continue
+			}
+		}
+		if w.skip != nil && w.skip(line) {
+			continue
+		}
+		if w.block != nil {
+			if blockDepth < 0 && w.block.MatchString(line) {
+				blockDepth = depth
+			}
+			inBlock := blockDepth >= 0
+			unquoted := quotedStringRegex.ReplaceAllString(line, "")
+			depth += strings.Count(unquoted, "{") - strings.Count(unquoted, "}")
+			if depth <= blockDepth {
+				blockDepth = -1
+			}
+			if !inBlock {
+				continue
+			}
+		}
+		// Replace the matched references starting from the end of the line, so the indexes stay valid
+		matches := w.regex.FindAllStringSubmatchIndex(line, -1)
+		for j := len(matches) - 1; j >= 0; j-- {
+			start, end := firstSubmatch(matches[j])
+			if start < 0 {
+				continue
+			}
+			if w.verbose {
+				log.Printf("Found %s in line %d of a %s, verify it is an image reference", line[start:end], i+1,
+					w.name)
+			}
+			pinned, ok := fa.pinImage(ctx, line[start:end])
+			if !ok {
+				continue
+			}
+			line = line[:start] + pinned + line[end:]
+		}
+		if line != lines[i] {
+			lines[i] = line
+			modified = true
+		}
+	}
+	return strings.Join(lines, "\n"), modified
+}
+
+// firstSubmatch returns the indexes of the first subgroup of the match which matched, or -1 if none did
+func firstSubmatch(match []int) (int, int) {
+	for k := 2; k+1 < len(match); k += 2 {
+		if match[k] >= 0 {
+			return match[k], match[k+1]
+		}
+	}
+	return -1, -1
+}
+
+// pinImage resolves the image reference to its digest using the images replacer, returning the reference with the
+// digest appended and whether it was pinned. References which are already pinned or fail to resolve are skipped,
+// the same way the replacer skips them
+func (fa *FrizbeeAction) pinImage(ctx context.Context, imageRef string) (string, bool) {
+	ref, err := fa.ImagesReplacer.ParseString(ctx, imageRef)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%s@%s", ref.Name, ref.Tag, ref.Ref), true
+}
@@ -0,0 +1,469 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pull_request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// committer makes the commits of the repository of the working directory with the identity of the action, signing
+// them if a GPG private key is set
+type committer struct {
+	repo        *git.Repository
+	worktree    *git.Worktree
+	name, email string
+	key         *openpgp.Entity
+}
+
+// newCommitter opens the repository of the working directory. The identity of the commits is the one of the GPG key
+// if the commits are signed, or the overridden author if any
+func newCommitter() (*committer, error) {
+	repo, worktree, err := openRepository()
+	if err != nil {
+		return nil, err
+	}
+	c := &committer{
+		repo:     repo,
+		worktree: worktree,
+		name:     "frizbee-action[bot]",
+		email:    "frizbee-action[bot]@users.noreply.github.com",
+	}
+	if AuthorName != "" {
+		c.name = AuthorName
+	}
+	if AuthorEmail != "" {
+		c.email = AuthorEmail
+	}
+	if GPGPrivateKey != "" {
+		if c.key, c.name, c.email, err = signingKey(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// openRepository opens the repository of the working directory along with its working tree
+func openRepository() (*git.Repository, *git.Worktree, error) {
+	repo, err := git.PlainOpen(".")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the repository: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the working tree: %w", err)
+	}
+	return repo, worktree, nil
+}
+
+// signingKey reads the GPG private key, decrypting it with the passphrase if any. It returns the key along with the
+// name and email of its user ID, which must be the identity of the commits for GitHub to verify them
+func signingKey() (*openpgp.Entity, string, string, error) {
+	keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(GPGPrivateKey))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read the GPG private key: %w", err)
+	}
+	if len(keys) == 0 || keys[0].PrivateKey == nil {
+		return nil, "", "", errors.New("the GPG private key has no private key")
+	}
+	key := keys[0]
+	if err := key.DecryptPrivateKeys([]byte(GPGPassphrase)); err != nil {
+		return nil, "", "", fmt.Errorf("failed to decrypt the GPG private key: %w", err)
+	}
+	id := key.PrimaryIdentity()
+	if id == nil || id.UserId == nil || id.UserId.Email == "" {
+		return nil, "", "", errors.New("the GPG private key has no user ID with an email")
+	}
+	return key, id.UserId.Name, id.UserId.Email, nil
+}
+
+// head returns the checked out commit
+func (c *committer) head() (plumbing.Hash, error) {
+	ref, err := c.repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to get the checked out commit: %w", err)
+	}
+	return ref.Hash(), nil
+}
+
+// commit commits the staged changes with the message as is, authored by the given signature, or the identity of the
+// committer if nil
+func (c *committer) commit(message string, author *object.Signature) (plumbing.Hash, error) {
+	signature := &object.Signature{Name: c.name, Email: c.email, When: time.Now()}
+	if author == nil {
+		author = signature
+	}
+	hash, err := c.worktree.Commit(message, &git.CommitOptions{Author: author, Committer: signature, SignKey: c.key})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to commit: %w", err)
+	}
+	return hash, nil
+}
+
+// show prints the commit along with its changes, like git show
+func (c *committer) show(hash plumbing.Hash) error {
+	commit, err := c.repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+	parent, err := commit.Parent(0)
+	if err != nil {
+		return fmt.Errorf("failed to get the parent of commit %s: %w", hash, err)
+	}
+	patch, err := parent.Patch(commit)
+	if err != nil {
+		return fmt.Errorf("failed to get the changes of commit %s: %w", hash, err)
+	}
+	_, err = fmt.Fprintf(Stdout, "%s\n%s", commit, patch)
+	return err
+}
+
+// openRemote returns the configured remote of the given name, or the one added for the given URL, i.e. pointing to
+// a fork
+func openRemote(repo *git.Repository, remote string) (*git.Remote, error) {
+	if !isRemoteURL(remote) {
+		r, err := repo.Remote(remote)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get remote %s: %w", remote, err)
+		}
+		return r, nil
+	}
+	// The URL may embed credentials, so it's left out of the errors
+	if err := repo.DeleteRemote(forkRemoteName); err != nil && !errors.Is(err, git.ErrRemoteNotFound) {
+		return nil, fmt.Errorf("failed to configure remote %s: %w", forkRemoteName, err)
+	}
+	r, err := repo.CreateRemote(&config.RemoteConfig{Name: forkRemoteName, URLs: []string{remote}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure remote %s: %w", forkRemoteName, err)
+	}
+	return r, nil
+}
+
+// remoteAuth returns the credentials of the remote, see urlAuth
+func remoteAuth(remote *git.Remote) transport.AuthMethod {
+	if urls := remote.Config().URLs; len(urls) > 0 {
+		return urlAuth(urls[0])
+	}
+	return nil
+}
+
+// urlAuth returns the token as the credentials of an HTTPS URL on the GitHub server, as the credentials configured for
+// the repository, i.e. by actions/checkout, are specific to the git binary. URLs with credentials are kept as is
+func urlAuth(remoteURL string) transport.AuthMethod {
+	u, err := url.Parse(remoteURL)
+	if err != nil || u.Scheme != "https" || u.User != nil || u.Host != Host || Token == "" {
+		return nil
+	}
+	return &githttp.BasicAuth{Username: tokenUser, Password: Token}
+}
+
+// fetch fetches the branch of the remote and returns the commit it points to, or false if the branch doesn't exist
+func (c *committer) fetch(remote *git.Remote, branch string) (plumbing.Hash, bool, error) {
+	tracking := plumbing.NewRemoteReferenceName(remote.Config().Name, branch)
+	refspec := config.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), tracking))
+	err := remote.Fetch(&git.FetchOptions{
+		RefSpecs: []config.RefSpec{refspec},
+		Auth:     remoteAuth(remote),
+		Tags:     git.NoTags,
+	})
+	switch {
+	case errors.Is(err, git.NoMatchingRefSpecError{}), errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return plumbing.ZeroHash, false, nil
+	case err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate):
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to fetch %s from %s: %w", branch, remote.Config().Name, err)
+	}
+	ref, err := c.repo.Reference(tracking, true)
+	if err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to get the fetched commit: %w", err)
+	}
+	return ref.Hash(), true, nil
+}
+
+// push pushes the commit to the branch of the remote, streaming the messages of the remote to the log. The pushes
+// rejected by the rules of the repository return ErrPushRejected with the violated rules reported by the remote
+func push(remote *git.Remote, hash plumbing.Hash, branch string, force bool) error {
+	refspec := fmt.Sprintf("%s:%s", hash, plumbing.NewBranchReferenceName(branch))
+	if force {
+		refspec = "+" + refspec
+	}
+	var messages bytes.Buffer
+	err := remote.Push(&git.PushOptions{
+		RemoteName: remote.Config().Name,
+		RefSpecs:   []config.RefSpec{config.RefSpec(refspec)},
+		Auth:       remoteAuth(remote),
+		Progress:   io.MultiWriter(os.Stderr, &messages),
+	})
+	if err == nil || errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	out := messages.String() + "\n" + err.Error() + "\n"
+	if nonFastForwardRegex.MatchString(out) {
+		return fmt.Errorf("%w: %s", errNonFastForward, branch)
+	}
+	if m := pushRejectedRegex.FindStringSubmatchIndex(out); m != nil {
+		// The violated rules follow until the details of the violations or the error of the push
+		var rules []string
+		for _, line := range strings.Split(out[m[1]:], "\n") {
+			line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "remote:"))
+			line = strings.TrimSpace(strings.TrimPrefix(line, "error:"))
+			if strings.HasPrefix(line, "Found ") || line == err.Error() {
+				break
+			}
+			if line != "" {
+				rules = append(rules, strings.TrimPrefix(line, "- "))
+			}
+		}
+		return fmt.Errorf("%w (%s: %s) %s", ErrPushRejected, out[m[2]:m[3]], strings.TrimSpace(out[m[4]:m[5]]),
+			strings.Join(rules, " "))
+	}
+	return fmt.Errorf("failed to push %s to %s: %w", branch, remote.Config().Name, err)
+}
+
+// treeChange is the change of a file by a commit, whose entries are nil if the file doesn't exist
+type treeChange struct {
+	path          string
+	before, after *object.TreeEntry
+}
+
+// replay recreates the commits made on top of the upstream commit on the onto commit, like git rebase --onto, which
+// go-git lacks. A commit only applies if the files it changes are unchanged on the onto commit, or changed the same
+// way, otherwise ErrBranchDiverged is returned before anything is changed. The commits keep their author and message,
+// and those left empty are dropped
+func (c *committer) replay(upstream, onto plumbing.Hash) error {
+	head, err := c.head()
+	if err != nil {
+		return err
+	}
+	var commits []*object.Commit
+	for hash := head; hash != upstream; {
+		commit, err := c.repo.CommitObject(hash)
+		if err != nil || commit.NumParents() != 1 {
+			return fmt.Errorf("%w: %s is not based on %s", ErrBranchDiverged, head, upstream)
+		}
+		commits = append([]*object.Commit{commit}, commits...)
+		hash = commit.ParentHashes[0]
+	}
+	ontoTree, err := c.tree(onto)
+	if err != nil {
+		return err
+	}
+
+	// Check that all the commits apply before changing anything
+	files := map[string]*object.TreeEntry{}
+	plans := make([][]treeChange, len(commits))
+	for i, commit := range commits {
+		changes, err := c.changes(commit.ParentHashes[0], commit.Hash)
+		if err != nil {
+			return err
+		}
+		for _, ch := range changes {
+			current, ok := files[ch.path]
+			if !ok {
+				current = findEntry(ontoTree, ch.path)
+			}
+			if sameEntry(current, ch.after) {
+				continue
+			}
+			if !sameEntry(current, ch.before) {
+				return fmt.Errorf("%w: %s was changed on %s", ErrBranchDiverged, ch.path, onto)
+			}
+			files[ch.path] = ch.after
+			plans[i] = append(plans[i], ch)
+		}
+	}
+
+	if err := c.reset(onto); err != nil {
+		return err
+	}
+	for i, commit := range commits {
+		if len(plans[i]) == 0 {
+			continue
+		}
+		for _, ch := range plans[i] {
+			if err := c.apply(ch); err != nil {
+				return err
+			}
+		}
+		author := commit.Author
+		if _, err := c.commit(commit.Message, &author); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tree returns the tree of the commit
+func (c *committer) tree(hash plumbing.Hash) (*object.Tree, error) {
+	commit, err := c.repo.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the tree of commit %s: %w", hash, err)
+	}
+	return tree, nil
+}
+
+// changes returns the changes of the files between the trees of the commits
+func (c *committer) changes(from, to plumbing.Hash) ([]treeChange, error) {
+	fromTree, err := c.tree(from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := c.tree(to)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare %s with %s: %w", from, to, err)
+	}
+	changes := make([]treeChange, 0, len(diff))
+	for _, ch := range diff {
+		change := treeChange{path: ch.To.Name}
+		if ch.From.Name != "" {
+			change.path = ch.From.Name
+			change.before = &ch.From.TreeEntry
+		}
+		if ch.To.Name != "" {
+			change.after = &ch.To.TreeEntry
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// findEntry returns the entry of the file in the tree, or nil if it doesn't exist
+func findEntry(tree *object.Tree, path string) *object.TreeEntry {
+	entry, err := tree.FindEntry(path)
+	if err != nil {
+		return nil
+	}
+	return entry
+}
+
+// sameEntry returns whether the entries are the same file, or both missing
+func sameEntry(a, b *object.TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+// reset moves the checked out branch to the commit, resetting the index and the working tree of the tracked files that
+// differ from it. The untracked files are kept, which go-git would remove when resetting the whole working tree
+func (c *committer) reset(hash plumbing.Hash) error {
+	status, err := c.worktree.Status()
+	if err != nil {
+		return fmt.Errorf("failed to get the status of the working tree: %w", err)
+	}
+	head, err := c.head()
+	if err != nil {
+		return err
+	}
+	changes, err := c.changes(head, hash)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, ch := range changes {
+		paths = append(paths, ch.path)
+	}
+	for path, s := range status {
+		if s.Staging != git.Untracked && (s.Staging != git.Unmodified || s.Worktree != git.Unmodified) {
+			paths = append(paths, path)
+		}
+	}
+	opts := &git.ResetOptions{Commit: hash, Mode: git.HardReset, Files: paths}
+	if len(paths) == 0 {
+		opts.Mode = git.SoftReset
+	}
+	if err := c.worktree.Reset(opts); err != nil {
+		return fmt.Errorf("failed to reset to %s: %w", hash, err)
+	}
+	return nil
+}
+
+// apply writes the change of the file to the working tree and stages it
+func (c *committer) apply(ch treeChange) error {
+	if ch.after == nil {
+		if _, err := c.worktree.Remove(ch.path); err != nil {
+			return fmt.Errorf("failed to remove file %s: %w", ch.path, err)
+		}
+		return nil
+	}
+	if err := writeBlob(c.repo, ch.path, ch.after); err != nil {
+		return err
+	}
+	if _, err := c.worktree.Add(ch.path); err != nil {
+		return fmt.Errorf("failed to stage file %s: %w", ch.path, err)
+	}
+	return nil
+}
+
+// writeBlob writes the blob of the tree entry to the path, with the mode of the entry
+func writeBlob(repo *git.Repository, path string, entry *object.TreeEntry) error {
+	blob, err := repo.BlobObject(entry.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to get the content of file %s: %w", path, err)
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return fmt.Errorf("failed to read the content of file %s: %w", path, err)
+	}
+	defer r.Close() // nolint:errcheck
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read the content of file %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil { // nolint:gosec
+		return fmt.Errorf("failed to create the directory of file %s: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace file %s: %w", path, err)
+	}
+	switch entry.Mode {
+	case filemode.Symlink:
+		err = os.Symlink(string(content), path)
+	case filemode.Executable:
+		err = os.WriteFile(path, content, 0755) // nolint:gosec
+	case filemode.Regular, filemode.Deprecated:
+		err = os.WriteFile(path, content, 0644) // nolint:gosec
+	default:
+		return fmt.Errorf("file %s has unsupported mode %s", path, entry.Mode)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file %s: %w", path, err)
+	}
+	return nil
+}
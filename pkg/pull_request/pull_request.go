@@ -16,51 +16,537 @@
 package pull_request
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	// ErrBranchDiverged is returned when the commits can't be appended to the existing branch of the pull request
+	ErrBranchDiverged = errors.New("the branch diverged")
+	// ErrPushRejected is returned when the push is rejected by the rules of the repository, i.e. a branch protection
+	// or a ruleset requiring signed commits
+	ErrPushRejected = errors.New("the push was rejected")
+	// errNonFastForward is returned when the push is rejected because the branch moved since it was fetched
+	errNonFastForward = errors.New("the branch moved since it was fetched")
+)
+
+var (
+	// remoteOwnerRegex extracts the owner from the URL of a GitHub remote, in either the HTTPS or SSH form
+	remoteOwnerRegex = regexp.MustCompile(`[/:]([^/:]+)/[^/]+?(\.git)?/?$`)
+	// Stdout is where the commits are shown along with their changes, and where the output of the hooks is streamed
+	Stdout io.Writer = os.Stdout
+	// Host is the host of the GitHub server the remotes are on, which differs on GitHub Enterprise Server
+	Host = DefaultHost
+	// Token authenticates the fetches and pushes to the HTTPS remotes of the GitHub server
+	Token string
+	// GPGPrivateKey is the armored GPG private key the commits are signed with, if any
+	GPGPrivateKey string
+	// GPGPassphrase is the passphrase of the GPG private key, if any
+	GPGPassphrase string
+	// AuthorName and AuthorEmail override the identity of the commits made with git, if set
+	AuthorName, AuthorEmail string
+	// CoAuthors are the "Name <email>" of the users credited with a Co-authored-by trailer in the commit messages
+	CoAuthors []string
+	// SkipCIMarker is appended to the subject of the commits, i.e. [skip ci] to not run the workflows on them
+	SkipCIMarker string
+	// Signoff adds a Signed-off-by trailer with the identity of the commits made with git, i.e. for DCO checks
+	Signoff bool
+	// pushRejectedRegex matches the error of a push rejected by a branch protection (GH006) or a ruleset (GH013),
+	// capturing its code and description
+	pushRejectedRegex = regexp.MustCompile(`(GH0[01][0-9]): ([^\n]*)\n`)
+	// nonFastForwardRegex matches the error of a push rejected because the branch has commits the pushed one lacks
+	nonFastForwardRegex = regexp.MustCompile(`non-fast-forward|fetch first`)
+	// PushRetries is the number of times the commits are rebased and pushed again when the branch moved
+	PushRetries = DefaultPushRetries
 )
 
-func runCommand(name string, args ...string) {
+// execCommand runs the command with the given extra environment variables, streaming its output to the log
+func execCommand(env []string, name string, args ...string) error {
 	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		log.Fatalf("Failed to run command %s %v: %v", name, args, err)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.Run()
+}
+
+// RunHook runs the user provided shell command in the current working directory, i.e. the repository root.
+// The command inherits the environment of the action, along with the given extra environment variables
+func RunHook(command string, env []string) error {
+	if err := execCommand(env, "sh", "-c", command); err != nil {
+		return fmt.Errorf("command %q failed: %w", command, err)
+	}
+	return nil
+}
+
+const (
+	// DefaultCommitMessage is the default message of the commit with the pinned references
+	DefaultCommitMessage = "frizbee: pin images and actions to commit hash"
+	// DefaultTitle is the default title of the pull request
+	DefaultTitle = "Frizbee: Pin images and actions to commit hash"
+	// DefaultBody is the default body of the pull request
+	DefaultBody = "This PR pins images and actions to their commit hash"
+	// DefaultPushRetries is the default number of times the commits are rebased and pushed again when the branch
+	// moved
+	DefaultPushRetries = 3
+	// DefaultSkipCIMarker is the default marker appended to the commit messages to skip the workflows
+	DefaultSkipCIMarker = "[skip ci]"
+	// DefaultCategoryCommitMessage is the default message of the commit of a category of pinned references
+	DefaultCategoryCommitMessage = "frizbee: pin %s to commit hash"
+	// DefaultCategoryTitle is the default title of the pull request of a category of pinned references
+	DefaultCategoryTitle = "Frizbee: Pin %s to commit hash"
+	// DefaultCategoryBody is the default body of the pull request of a category of pinned references
+	DefaultCategoryBody = "This PR pins %s to their commit hash"
+	// DefaultDirectoryCommitMessage is the default message of the commit of the pinned references of a directory
+	DefaultDirectoryCommitMessage = "frizbee: pin images and actions to commit hash in %s"
+	// DefaultDirectoryTitle is the default title of the pull request of the pinned references of a directory
+	DefaultDirectoryTitle = "Frizbee: Pin images and actions to commit hash in %s"
+	// DefaultDirectoryBody is the default body of the pull request of the pinned references of a directory
+	DefaultDirectoryBody = "This PR pins images and actions in %s to their commit hash"
+	// DefaultChangelogCommitMessage is the default message of the commit adding the changelog fragment
+	DefaultChangelogCommitMessage = "frizbee: add changelog fragment"
+	// DefaultBaseBranch is the branch the pull request targets if the default branch of the repository is unknown
+	DefaultBaseBranch = "main"
+	// DefaultBranchName is the default name of the branch the changes are pushed to for the pull request
+	DefaultBranchName = "modify-workflows"
+	// DefaultRemote is the default git remote the branch is pushed to
+	DefaultRemote = "origin"
+	// DefaultHost is the host of github.com
+	DefaultHost = "github.com"
+	// forkRemoteName is the name of the git remote added when the remote is given as a URL
+	forkRemoteName = "frizbee-fork"
+	// tokenUser is the user name the token is embedded in the remote URLs with
+	tokenUser = "x-access-token"
+)
+
+// ServerHost returns the host of the GitHub server URL, i.e. the GITHUB_SERVER_URL of the workflow run
+func ServerHost(serverURL string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid GitHub server URL %q", serverURL)
+	}
+	return u.Host, nil
+}
+
+// isRemoteURL returns true if the remote is a URL rather than the name of a configured remote
+func isRemoteURL(remote string) bool {
+	return strings.Contains(remote, "://") || strings.HasPrefix(remote, "git@")
+}
+
+// RemoteOwner returns the owner of the repository the remote points to, i.e. the fork owner
+func RemoteOwner(remote string) (string, error) {
+	url := remote
+	if !isRemoteURL(remote) {
+		repo, _, err := openRepository()
+		if err != nil {
+			return "", err
+		}
+		r, err := repo.Remote(remote)
+		if err != nil {
+			return "", fmt.Errorf("failed to get the URL of remote %s: %w", remote, err)
+		}
+		if len(r.Config().URLs) == 0 {
+			return "", fmt.Errorf("remote %s has no URL", remote)
+		}
+		url = r.Config().URLs[0]
+	}
+	m := remoteOwnerRegex.FindStringSubmatch(url)
+	if m == nil {
+		return "", fmt.Errorf("failed to parse the repository owner from remote %s", remote)
 	}
+	return m[1], nil
 }
 
-func CommitAndPush() {
-	// Configure git
-	runCommand("git", "config", "--global", "--add", "safe.directory", "/github/workspace")
-	runCommand("git", "config", "--global", "user.name", "frizbee-action[bot]")
-	runCommand("git", "config", "--global", "user.email", "frizbee-action[bot]@users.noreply.github.com")
+// Commit is one of a series of commits, made by writing the given content of the files and committing them
+type Commit struct {
+	// Message is the message of the commit
+	Message string
+	// Files is the content of the files of the commit by path
+	Files map[string]string
+}
 
-	// Get git status
-	runCommand("git", "status")
+// CommitAndPush commits the changes to a new branch and pushes it to the given remote, which is either the name of a
+// configured remote or a URL
+func CommitAndPush(message, remote, branch string, force bool) error {
+	return CommitSeriesAndPush([]Commit{{Message: message}}, remote, branch, force)
+}
 
-	// Create a new branch
-	branchName := "modify-workflows"
-	runCommand("git", "checkout", "-b", branchName)
+// CommitSeriesAndPush commits the changes to a new branch as a series of commits and pushes it to the given remote.
+// If the branch already exists on the remote, the commits are rebased on top of it, keeping the commits pushed to it
+// since, i.e. the fixups of the reviewers. If they can't be rebased, the branch is only overwritten if force is set
+func CommitSeriesAndPush(commits []Commit, remote, branch string, force bool) error {
+	c, err := newCommitter()
+	if err != nil {
+		return err
+	}
 
-	// Add changes
-	runCommand("git", "add", ".")
+	// Create a new branch, remembering where it starts to only rebase its own commits
+	base, err := c.head()
+	if err != nil {
+		return err
+	}
+	err = c.worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branch),
+		Create: true,
+		Keep:   true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create branch %s: %w", branch, err)
+	}
 
-	// Commit changes
-	runCommand("git", "commit", "-m", "frizbee: pin images and actions to commit hash")
+	if err := c.commitSeries(commits); err != nil {
+		return err
+	}
 
-	// Show the changes
-	runCommand("git", "show")
+	r, err := openRemote(c.repo, remote)
+	if err != nil {
+		return err
+	}
+
+	// Append the commits to the existing branch, if any
+	upstream := base
+	fetched, exists, err := c.fetch(r, branch)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := c.replay(upstream, fetched); err != nil {
+			if !errors.Is(err, ErrBranchDiverged) {
+				return err
+			}
+			if !force {
+				return fmt.Errorf("%w: %s can't be rebased on the branch of %s, set force to overwrite it",
+					ErrBranchDiverged, branch, remote)
+			}
+			log.Printf("Warning: %s can't be rebased on the branch of %s, overwriting it", branch, remote)
+			head, err := c.head()
+			if err != nil {
+				return err
+			}
+			return push(r, head, branch, true)
+		}
+		upstream = fetched
+	}
 
 	// Push changes
-	runCommand("git", "push", "origin", branchName, "--force")
+	return c.pushWithRebase(r, branch, upstream)
+}
+
+// pushWithRebase pushes the checked out commit to the branch of the remote. If the push is rejected because the branch
+// moved since it was fetched, i.e. another process pushed to it, the commits made on top of the upstream commit are
+// rebased on the branch and pushed again, up to PushRetries times
+func (c *committer) pushWithRebase(r *git.Remote, branch string, upstream plumbing.Hash) error {
+	for attempt := 1; ; attempt++ {
+		head, err := c.head()
+		if err != nil {
+			return err
+		}
+		err = push(r, head, branch, false)
+		if !errors.Is(err, errNonFastForward) || attempt > PushRetries {
+			return err
+		}
+		log.Printf("Warning: %s moved since it was fetched, rebasing the commits on it (attempt %d of %d)", branch,
+			attempt, PushRetries)
+		fetched, exists, err := c.fetch(r, branch)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := c.replay(upstream, fetched); err != nil {
+			return fmt.Errorf("%w: the commits can't be rebased on %s, which moved since it was fetched",
+				ErrBranchDiverged, branch)
+		}
+		upstream = fetched
+	}
+}
+
+// CommitAndPushCurrentBranch commits the changes to the checked out branch and pushes them to the given branch of the
+// remote, which is either the name of a configured remote or a URL
+func CommitAndPushCurrentBranch(message, remote, branch string) error {
+	return CommitSeriesAndPushCurrentBranch([]Commit{{Message: message}}, remote, branch)
+}
+
+// CommitSeriesAndPushCurrentBranch commits the changes to the checked out branch as a series of commits and pushes
+// them to the given branch of the remote, rebasing them if the branch moved since it was checked out
+func CommitSeriesAndPushCurrentBranch(commits []Commit, remote, branch string) error {
+	c, err := newCommitter()
+	if err != nil {
+		return err
+	}
+	upstream, err := c.head()
+	if err != nil {
+		return err
+	}
+	if err := c.commitSeries(commits); err != nil {
+		return err
+	}
+	r, err := openRemote(c.repo, remote)
+	if err != nil {
+		return err
+	}
+	return c.pushWithRebase(r, branch, upstream)
+}
+
+// commitSeries makes the commits in order, each one writing and committing its files only, and shows them. The last
+// commit includes all the remaining changes
+func (c *committer) commitSeries(commits []Commit) error {
+	for i, commit := range commits {
+		paths := make([]string, 0, len(commit.Files))
+		for path, content := range commit.Files {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil { // nolint:gosec
+				return fmt.Errorf("failed to create the directory of file %s: %w", path, err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil { // nolint:gosec
+				return fmt.Errorf("failed to write file %s: %w", path, err)
+			}
+			paths = append(paths, path)
+		}
+		if i == len(commits)-1 {
+			if err := c.worktree.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+				return fmt.Errorf("failed to stage the changes: %w", err)
+			}
+		} else {
+			sort.Strings(paths)
+			for _, path := range paths {
+				if _, err := c.worktree.Add(path); err != nil {
+					return fmt.Errorf("failed to stage file %s: %w", path, err)
+				}
+			}
+		}
+		hash, err := c.commit(c.message(commit.Message), nil)
+		if err != nil {
+			return err
+		}
+		if err := c.show(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// message returns the commit message as committed, see FullMessage, with a Signed-off-by trailer of the identity of
+// the commits if Signoff is set
+func (c *committer) message(message string) string {
+	message = strings.TrimRight(FullMessage(message), "\n")
+	if !Signoff {
+		return message + "\n"
+	}
+	// The trailer joins the Co-authored-by ones, if any
+	separator := "\n\n"
+	if len(CoAuthors) > 0 {
+		separator = "\n"
+	}
+	return fmt.Sprintf("%s%sSigned-off-by: %s <%s>\n", message, separator, c.name, c.email)
+}
+
+// ResolveRef returns the SHA of the commit the ref points to, fetching it from the remote if it is not available
+// locally, i.e. in a shallow clone
+func ResolveRef(ref, remote string) (string, error) {
+	repo, _, err := openRepository()
+	if err != nil {
+		return "", err
+	}
+	if hash, err := repo.ResolveRevision(plumbing.Revision(ref)); err == nil {
+		return hash.String(), nil
+	}
+	r, err := repo.Remote(remote)
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote %s: %w", remote, err)
+	}
+	// Like git fetch, the ref is either a SHA, a full ref, a tag or a branch
+	candidates := []string{"refs/tags/" + ref, "refs/heads/" + ref}
+	if plumbing.IsHash(ref) || strings.HasPrefix(ref, "refs/") {
+		candidates = []string{ref}
+	}
+	fetched := plumbing.ReferenceName("refs/frizbee/ref")
+	for _, candidate := range candidates {
+		err = r.Fetch(&git.FetchOptions{
+			RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", candidate, fetched))},
+			Depth:    1,
+			Auth:     remoteAuth(r),
+			Tags:     git.NoTags,
+		})
+		if err == nil || errors.Is(err, git.NoErrAlreadyUpToDate) {
+			break
+		}
+	}
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return "", fmt.Errorf("failed to fetch %s from %s: %w", ref, remote, err)
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(fetched))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	return hash.String(), nil
+}
+
+// Clone clones the branch of the repository at the URL into the directory, or its default branch if empty,
+// authenticating with the token
+func Clone(remote, branch, dir string) error {
+	opts := &git.CloneOptions{URL: remote, Auth: urlAuth(remote), Tags: git.NoTags}
+	if branch != "" {
+		opts.ReferenceName = plumbing.NewBranchReferenceName(branch)
+		opts.SingleBranch = true
+	}
+	if _, err := git.PlainClone(dir, false, opts); err != nil {
+		return fmt.Errorf("failed to clone %s: %w", remote, err)
+	}
+	return nil
+}
+
+// HeadSHA returns the SHA of the checked out commit
+func HeadSHA() (string, error) {
+	repo, _, err := openRepository()
+	if err != nil {
+		return "", err
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
 }
 
-func CreatePullRequest() {
-	title := "Frizbee: Pin images and actions to commit hash"
-	body := "This PR pins images and actions to their commit hash"
-	head := "modify-workflows"
-	base := "main"
-	runCommand("gh", "pr", "create", "--title", title, "--body", body, "--head", head, "--base", base)
+// ExtractCommit writes the files of the commit to the given directory, leaving the working tree of the repository
+// untouched
+func ExtractCommit(dir, sha string) error {
+	repo, _, err := openRepository()
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return fmt.Errorf("failed to get commit %s: %w", sha, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to get the tree of commit %s: %w", sha, err)
+	}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		return writeBlob(repo, filepath.Join(dir, f.Name), &object.TreeEntry{Name: f.Name, Mode: f.Mode, Hash: f.Hash})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check out %s in %s: %w", sha, dir, err)
+	}
+	return nil
+}
+
+// WorkingTreeChanges returns the paths of the files changed and deleted in the working tree since the checked out
+// commit, staging all the changes to list them
+func WorkingTreeChanges() ([]string, []string, error) {
+	_, worktree, err := openRepository()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := worktree.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+		return nil, nil, fmt.Errorf("failed to stage the changes: %w", err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the changes: %w", err)
+	}
+	var changed, deleted []string
+	for path, s := range status {
+		switch s.Staging {
+		case git.Unmodified, git.Untracked:
+		case git.Deleted:
+			deleted = append(deleted, path)
+		default:
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(deleted)
+	return changed, deleted, nil
+}
+
+// RemoteBranchExists returns whether the branch exists on the remote, which is either the name of a configured remote
+// or a URL
+func RemoteBranchExists(remote, branch string) (bool, error) {
+	repo, _, err := openRepository()
+	if err != nil {
+		return false, err
+	}
+	r, err := openRemote(repo, remote)
+	if err != nil {
+		return false, err
+	}
+	refs, err := r.List(&git.ListOptions{Auth: remoteAuth(r)})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to list the branches of %s: %w", remote, err)
+	}
+	name := plumbing.NewBranchReferenceName(branch)
+	for _, ref := range refs {
+		if ref.Name() == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckoutClean checks out the commit, discarding the changes of the working tree to the tracked files
+func CheckoutClean(sha string) error {
+	repo, worktree, err := openRepository()
+	if err != nil {
+		return err
+	}
+	c := &committer{repo: repo, worktree: worktree}
+	// Detach from the checked out branch first, so it's left as is
+	head, err := c.head()
+	if err != nil {
+		return err
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, head)); err != nil {
+		return fmt.Errorf("failed to detach from the checked out branch: %w", err)
+	}
+	return c.reset(plumbing.NewHash(sha))
+}
+
+// CheckBranchName returns an error if the name is not a valid branch name
+func CheckBranchName(name string) error {
+	if err := plumbing.NewBranchReferenceName(name).Validate(); err != nil {
+		return fmt.Errorf("%q is not a valid branch name", name)
+	}
+	return nil
+}
+
+// FullMessage returns the commit message as committed, with the skip marker appended to its subject if any, and a
+// Co-authored-by trailer for each of the co-authors
+func FullMessage(message string) string {
+	if SkipCIMarker != "" {
+		subject, body, found := strings.Cut(message, "\n")
+		message = subject + " " + SkipCIMarker
+		if found {
+			message += "\n" + body
+		}
+	}
+	if len(CoAuthors) == 0 {
+		return message
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(message, "\n"))
+	b.WriteString("\n")
+	for _, coAuthor := range CoAuthors {
+		b.WriteString("\nCo-authored-by: " + coAuthor)
+	}
+	return b.String()
 }
@@ -0,0 +1,29 @@
+#!/usr/bin/env bash
+#
+# Copyright 2024 Stacklok, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -euo pipefail
+
+# Build the application image on top of a pinned base image
+docker build --build-arg BASE_IMAGE=nginx:1.25 -t app .
+
+# Build the tools image with buildah
+ctr=$(buildah from alpine:3.19)
+buildah run "$ctr" apk add --no-cache curl
+buildah commit "$ctr" tools
+
+# Variables and untagged references are left alone
+docker pull "$CACHE_IMAGE"
+docker pull redis
@@ -0,0 +1,24 @@
+resource "docker_image" "nginx" {
+  name = "nginx:1.25"
+}
+
+resource "docker_container" "web" {
+  name  = "web"
+  image = "nginx:1.25"
+
+  ports {
+    internal = 80
+    external = 8080
+  }
+}
+
+resource "docker_container" "cache" {
+  name = "cache"
+  # Images built from variables or other resources are skipped
+  image = docker_image.nginx.image_id
+}
+
+resource "docker_container" "worker" {
+  name  = "worker"
+  image = "${var.registry}/worker:${var.tag}"
+}
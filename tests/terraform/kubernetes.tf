@@ -0,0 +1,30 @@
+resource "kubernetes_manifest" "deployment" {
+  manifest = {
+    apiVersion = "apps/v1"
+    kind       = "Deployment"
+    metadata = {
+      name      = "api"
+      namespace = "default"
+    }
+    spec = {
+      template = {
+        spec = {
+          containers = [
+            {
+              name  = "api"
+              image = "ghcr.io/stacklok/minder/server:latest"
+            },
+            {
+              name  = "sidecar"
+              image = "busybox:1.36"
+            },
+          ]
+        }
+      }
+    }
+  }
+}
+
+variable "image" {
+  default = "alpine:3.20"
+}